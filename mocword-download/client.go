@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/time/rate"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+// maxRedirects is the max number of redirects followed per request.
+const maxRedirects = 10
+
+// httpProtocols are the values of -http-protocol.
+var httpProtocols = []string{"auto", "http1", "http2"}
+
+// newHTTPClient builds the client shared by every request.
+//
+// The proxy is taken from proxy if it is not empty, which overrides
+// HTTP_PROXY and HTTPS_PROXY of the environment. NO_PROXY is honored in both
+// cases as by http.ProxyFromEnvironment.
+//
+// Up to maxIdleConns idle connections are kept per host, so that the many
+// requests to the storage reuse their TLS connections instead of handshaking
+// again. protocol is one of httpProtocols: "auto" negotiates HTTP/2 with ALPN
+// and falls back to HTTP/1.1, while "http1" and "http2" allow only one of
+// them. HTTP/2 multiplexes every request on one connection, which saves
+// handshakes but shares one TCP window, so that separate HTTP/1.1 connections
+// may be faster on a lossy link.
+func newHTTPClient(proxy string, maxIdleConns int, protocol string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	transport.MaxIdleConns = max(transport.MaxIdleConns, maxIdleConns)
+	transport.IdleConnTimeout = 90 * time.Second
+
+	protocols := new(http.Protocols)
+	switch protocol {
+	case "http1":
+		protocols.SetHTTP1(true)
+	case "http2":
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	}
+	transport.Protocols = protocols
+
+	if proxy != "" {
+		u, err := parseProxyURL(proxy)
+		if err != nil {
+			return nil, err
+		}
+		config := httpproxy.FromEnvironment()
+		config.HTTPProxy = u.String()
+		config.HTTPSProxy = u.String()
+		proxyFunc := config.ProxyFunc()
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			return proxyFunc(req.URL)
+		}
+	}
+
+	return &http.Client{Transport: transport, CheckRedirect: checkRedirect}, nil
+}
+
+// newSourceClient builds the client of the requests to the dataset storage:
+// the client of newHTTPClient sending the User-Agent and header with every
+// request, throttled to rateLimit bytes/sec if positive.
+func newSourceClient(proxy string, maxIdleConns int, protocol string, header http.Header, rateLimit int) (mocword.HTTPClient, error) {
+	client, err := newHTTPClient(proxy, maxIdleConns, protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	var httpClient mocword.HTTPClient = &headerClient{client: client, header: header}
+	if rateLimit > 0 {
+		httpClient = newRateLimitClient(httpClient, rateLimit)
+	}
+	return httpClient, nil
+}
+
+// checkRedirect follows up to maxRedirects redirects, so that a redirect
+// loop of a misconfigured mirror fails instead of hanging.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects from %s", maxRedirects, via[0].URL)
+	}
+	slog.Debug("redirect", "from", via[len(via)-1].URL.String(), "to", req.URL.String(), "hop", len(via))
+	return nil
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy: not an absolute url: %q", proxy)
+	}
+	return u, nil
+}
+
+// rateLimitClient throttles the bodies of the responses of client with a
+// limiter shared by every request, so that the aggregate download speed stays
+// under the limit.
+type rateLimitClient struct {
+	client  mocword.HTTPClient
+	limiter *rate.Limiter
+}
+
+func newRateLimitClient(client mocword.HTTPClient, bytesPerSec int) *rateLimitClient {
+	return &rateLimitClient{
+		client:  client,
+		limiter: rate.NewLimiter(rate.Limit(bytesPerSec), bytesPerSec),
+	}
+}
+
+func (c *rateLimitClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &rateLimitedReader{ctx: req.Context(), r: resp.Body, limiter: c.limiter}
+	return resp, nil
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.ReadCloser
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (r *rateLimitedReader) Close() error {
+	return r.r.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitClient(t *testing.T) {
+	const size, limit = 1000, 1000
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", size)))
+	}))
+	defer srv.Close()
+
+	// The burst of the limiter lets the first limit bytes through at once, so
+	// that two concurrent files take at least a second together.
+	client := newRateLimitClient(srv.Client(), limit)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequest("GET", srv.URL, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+
+			if n, err := io.Copy(io.Discard, resp.Body); err != nil || n != size {
+				t.Errorf("read %d bytes, %v, want %d", n, err, size)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if elapsed, want := time.Since(start), time.Duration(2*size-limit)*time.Second/limit; elapsed < want-50*time.Millisecond {
+		t.Errorf("read 2 files of %d bytes under %d bytes/sec in %v, want at least %v", size, limit, elapsed, want)
+	}
+}
+
+// BenchmarkHTTPProtocol downloads files concurrently from a TLS server with
+// the client of each -protocol.
+func BenchmarkHTTPProtocol(b *testing.B) {
+	const size = 1 << 20
+	body := strings.Repeat("x", size)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, bm := range []struct {
+		protocol   string
+		protoMajor int
+	}{
+		{"http1", 1},
+		{"http2", 2},
+	} {
+		b.Run(bm.protocol, func(b *testing.B) {
+			client, err := newHTTPClient("", 4, bm.protocol)
+			if err != nil {
+				b.Fatal(err)
+			}
+			transport := client.Transport.(*http.Transport)
+			transport.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+			transport.TLSClientConfig.NextProtos = nil
+			defer transport.CloseIdleConnections()
+
+			b.SetBytes(size)
+			b.SetParallelism(4)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get(srv.URL)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					n, err := io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					if err != nil || n != size {
+						b.Errorf("read %d bytes, %v, want %d", n, err, size)
+						return
+					}
+					if resp.ProtoMajor != bm.protoMajor {
+						b.Errorf("protocol = %s, want HTTP/%d", resp.Proto, bm.protoMajor)
+						return
+					}
+				}
+			})
+		})
+	}
+}
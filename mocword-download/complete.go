@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+// runComplete runs the complete subcommand, which prints the n-grams of an
+// fst export starting with a prefix and their counts:
+//
+//	mocword-download complete -limit 10 ngrams.fst "the "
+func runComplete(args []string) error {
+	fs := flag.NewFlagSet("complete", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mocword-download complete [flags] FST PREFIX")
+		fs.PrintDefaults()
+	}
+
+	limit := fs.Int("limit", 10, "max number of completions, or 0 for all")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 2 {
+		return errors.New("cannot parse flags: want an fst file and a prefix")
+	}
+
+	completions, err := mocword.CompleteFST(fs.Arg(0), fs.Arg(1), *limit)
+	if err != nil {
+		return err
+	}
+	for _, c := range completions {
+		fmt.Printf("%s\t%d\n", strings.Join(c.Tokens, " "), c.Count)
+	}
+	return nil
+}
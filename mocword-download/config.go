@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// loadConfig sets the flags of fs from the JSON object in fname, whose keys
+// are flag names such as "language" or "output-dir". The flags given on the
+// command line override the file. A list sets a repeatable flag once per
+// element, and is joined with commas for the others. The keys which are flags
+// of others but not of fs, such as the flags of the main command in the
+// config of a subcommand, are skipped.
+func loadConfig(fs *flag.FlagSet, fname string, others *flag.FlagSet) error {
+	buf, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return fmt.Errorf("cannot load config: %w", err)
+	}
+
+	var config map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&config); err != nil {
+		return fmt.Errorf("cannot load config %s: %w", fname, err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil && others != nil && others.Lookup(name) != nil {
+			continue
+		}
+		if f == nil || name == "config" {
+			return fmt.Errorf("cannot load config %s: unknown flag: %q", fname, name)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		values, err := configValues(config[name])
+		if err != nil {
+			return fmt.Errorf("cannot load config %s: %s: %w", fname, name, err)
+		}
+		if _, ok := f.Value.(*headerFlag); !ok {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := fs.Set(name, value); err != nil {
+				return fmt.Errorf("cannot load config %s: %s: %w", fname, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// configValues formats a JSON value of a config as flag values.
+func configValues(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		var values []string
+		for _, elem := range v {
+			vs, err := configValues(elem)
+			if err != nil || len(vs) != 1 {
+				return nil, fmt.Errorf("invalid list element: %v", elem)
+			}
+			values = append(values, vs...)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("invalid value: %v", v)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+var defaultUserAgent = "mocword-builder/" + mocword.ReadBuildInfo().Version
+
+// headerFlag is a repeatable "Key: Value" flag.
+type headerFlag struct {
+	header http.Header
+}
+
+func (f *headerFlag) String() string {
+	if f == nil || f.header == nil {
+		return ""
+	}
+
+	var kvs []string
+	for key, values := range f.header {
+		for _, value := range values {
+			kvs = append(kvs, key+": "+value)
+		}
+	}
+	return strings.Join(kvs, ", ")
+}
+
+func (f *headerFlag) Set(s string) error {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return fmt.Errorf("invalid header %q: want \"Key: Value\"", s)
+	}
+
+	key := strings.TrimSpace(s[:i])
+	value := strings.TrimSpace(s[i+1:])
+	if !validHeaderKey(key) {
+		return fmt.Errorf("invalid header key %q", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("invalid header value %q", value)
+	}
+
+	if f.header == nil {
+		f.header = make(http.Header)
+	}
+	f.header.Add(textproto.CanonicalMIMEHeaderKey(key), value)
+	return nil
+}
+
+// validHeaderKey reports whether key is a non-empty HTTP token.
+func validHeaderKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if r <= ' ' || r >= 0x7f || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// headerClient sets the User-Agent and extra headers on every request before
+// sending it with client.
+type headerClient struct {
+	client mocword.HTTPClient
+	header http.Header
+}
+
+func (c *headerClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", defaultUserAgent)
+	}
+	for key, values := range c.header {
+		req.Header[key] = values
+	}
+	return c.client.Do(req)
+}
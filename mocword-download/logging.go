@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+var validLogLevels = []string{"debug", "info", "warn", "error"}
+
+var validLogFormats = []string{"text", "json"}
+
+// newLogger returns a logger writing to w at level in format, which are one
+// of validLogLevels and validLogFormats.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format: %q", format)
+}
+
+// logLevel returns level, raised to "warn" if quiet.
+func logLevel(level string, quiet bool) string {
+	if !quiet {
+		return level
+	}
+	if lvl, err := parseLogLevel(level); err == nil && lvl > slog.LevelWarn {
+		return level
+	}
+	return "warn"
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level: %q", level)
+}
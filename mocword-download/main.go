@@ -1,76 +1,618 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"io"
+	"log/slog"
+	"net/url"
 	"os"
-	"path"
-	"path/filepath"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 	"unicode/utf8"
 
-	"github.com/PuerkitoBio/goquery"
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
 )
 
-var validLanguages = []string{
-	"eng",
-	"eng-us",
-	"eng-gb",
-	"eng-fiction",
-	"chi_sim",
-	"fre",
-	"ger",
-	"heb",
-	"ita",
-	"rus",
-	"spa",
-}
-
-var validNgrams = []string{"1", "2", "3", "4", "5"}
-
 var flagLanguage = flag.String(
-	"language", strings.Join(validLanguages, ","),
-	"comma separated language names\n("+strings.Join(validLanguages, ",")+")\n",
+	"language", strings.Join(mocword.Languages, ","),
+	"comma separated language names, or \"all\"\n("+strings.Join(mocword.Languages, ",")+")\n",
 )
 
 var flagNgram = flag.String(
-	"ngram", strings.Join(validNgrams, ","),
-	"comma separated ngram number ("+strings.Join(validNgrams, ",")+")",
+	"ngram", strings.Join(mocword.Ngrams, ","),
+	"comma separated ngram number, or \"all\" ("+strings.Join(mocword.Ngrams, ",")+")",
+)
+
+var flagSource = addSourceFlags(flag.CommandLine)
+
+var flagRateLimit = flag.Int("rate-limit", 0, "max aggregate download speed in bytes/sec (0 means unlimited)")
+
+var flagStrict = flag.Bool(
+	"strict", false,
+	"fail if a selected language does not publish a selected ngram instead of skipping it, or on the first data file which cannot be downloaded instead of exiting with 2 after the others",
+)
+
+var flagForce = flag.Bool(
+	"force", false,
+	"download and build every selected file again, ignoring the existing files and the manifest; partial downloads are discarded instead of resumed, and -db is rebuilt into <db>.tmp which replaces it when complete",
+)
+
+var flagReport = flag.String("report", "", "write a JSON report of the run and the outcome of every download to this file when it finishes")
+
+var flagMaxFiles = flag.Int("max-files", 0, "download and build only the first N files of each language and ngram, e.g. for a quick test (0 means all)")
+
+var flagMaxIndexSize = flag.Int64("max-index-size", mocword.DefaultMaxIndexSize, "max size in bytes of an index page, which fails to be fetched if it is larger")
+
+var flagMetricsAddr = flag.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address during the run, such as :9090")
+
+var flagProgress = flag.Bool("progress", true, "report the download progress with an ETA and the rows inserted by the build")
+
+var flagProgressInterval = flag.Duration("progress-interval", 10*time.Second, "interval of the progress logs")
+
+var flagNoSpaceCheck = flag.Bool("no-space-check", false, "do not check the free disk space before downloading")
+
+var flagOutputDir = flag.String("output-dir", ".", "directory to save downloaded files")
+
+var flagTmpDir = flag.String(
+	"tmp-dir", "",
+	"directory for the partial downloads instead of the output dir, such as a fast local disk (files are copied into the output dir across filesystems)",
+)
+
+var flagCleanTmp = flag.Bool("clean-tmp", false, "remove the temp files left in the output dir and -tmp-dir by a killed run on startup")
+
+var flagCleanTmpAge = flag.Duration("clean-tmp-age", 24*time.Hour, "minimum age of the temp files removed with -clean-tmp")
+
+var flagChecksumFile = flag.String(
+	"checksum-file", "",
+	"file of \"<sha256>  <url>\" lines to verify the downloaded files against",
+)
+
+var flagWriteChecksums = flag.Bool(
+	"write-checksums", false,
+	"write the SHA-256 of the downloaded files to checksums.txt in the output dir unless -checksum-file is given",
+)
+
+var flagDecompress = flag.Bool("decompress", false, "save the data files decompressed without their .gz or .zst suffix")
+
+var flagVerifyGzip = flag.Bool(
+	"verify-gzip", false,
+	"decompress each download completely to check its integrity before accepting it",
+)
+
+var flagMaxRetries = flag.Int("max-retries", 5, "max number of retries on transient errors of the downloads and index pages")
+
+var flagRetryBaseDelay = flag.Duration("retry-base-delay", time.Second, "delay before the first retry, doubled on each retry")
+
+var flagRetryMaxDelay = flag.Duration("retry-max-delay", 30*time.Second, "max delay before a retry")
+
+var flagRetryJitter = flag.Float64("retry-jitter", 0, "fraction from 0 to 1 of each retry delay which is randomized")
+
+var flagMaxRetryAfter = flag.Duration(
+	"max-retry-after", 5*time.Minute,
+	"max delay honored of the Retry-After header of a 429 or 503 response, which replaces the backoff",
+)
+
+var flagTimeout = flag.Duration(
+	"timeout", 10*time.Minute,
+	"timeout of each download attempt; an interrupted download is resumed on retry (0 means no timeout)",
+)
+
+var flagDeadline = flag.Duration(
+	"deadline", 0,
+	"max duration of the whole run, after which it stops and the next run resumes it (0 means no deadline)",
+)
+
+var flagDryRun = flag.Bool("dry-run", false, "print the data urls to download and exit without downloading")
+
+var flagVerify = flag.Bool("verify", false, "check that every listed file exists in the output dir after downloading")
+
+var flagDB = flag.String("db", "", "path of the SQLite database to build from the downloaded files (no build if empty)")
+
+var flagDSN = flag.String("dsn", "", "PostgreSQL connection string of the database to build instead of -db")
+
+var flagFormat = flag.String(
+	"format", "",
+	"also export the built ngrams to -export in this format ("+strings.Join(mocword.Formats, ",")+")",
+)
+
+var flagExport = flag.String("export", "-", "path of the file to export with -format (\"-\" is stdout, \".gz\" is gzipped and \".zst\" zstd compressed unless -compress is given)")
+
+var flagCompress = flag.String(
+	"compress", "",
+	"compression of -export ("+strings.Join(mocword.Compressions, ",")+"), told by its extension if empty (-format=fst is never compressed)",
+)
+
+var flagCompressLevel = flag.Int("compress-level", 0, "level of -compress, 1-9 for gzip and 1-22 for zstd (0 means the default level)")
+
+var flagCSVDelim = flag.String("csv-delim", ",", "field delimiter of -format=csv (\"\\t\" or \"tab\" for a tab)")
+
+var flagBatchSize = flag.Int("batch-size", 10000, "number of rows inserted per transaction while building the db")
+
+var flagMinCount = flag.Int64("min-count", 0, "skip ngrams whose total match count is below this while building the db")
+
+var flagYearStart = flag.Int("year-start", 0, "first year (inclusive) of the counts summed while building the db")
+
+var flagYearEnd = flag.Int("year-end", 9999, "last year (inclusive) of the counts summed while building the db")
+
+var flagSkipIndex = flag.Bool("skip-index", false, "do not create indexes after building the db")
+
+var flagOptimize = flag.Bool("optimize", true, "run VACUUM and ANALYZE on the SQLite db after building the indexes")
+
+var flagDeterministic = flag.Bool("deterministic", false, "build byte-identical databases and exports from the same data files and flags")
+
+var flagSafeMode = flag.Bool("safe-mode", false, "use the default SQLite journaling instead of the fast WAL settings")
+
+var flagSQLiteSynchronous = flag.String(
+	"sqlite-synchronous", "NORMAL",
+	"SQLite synchronous pragma unless -safe-mode ("+strings.Join(mocword.SynchronousModes, ",")+")",
+)
+
+var flagSQLiteCacheSize = flag.Int(
+	"sqlite-cache-size", -1024*1024,
+	"SQLite cache_size pragma unless -safe-mode (negative means KiB)",
+)
+
+var flagSourceDir = flag.String(
+	"source-dir", "",
+	"build from the data files under this directory, such as a mirror of the dataset, without downloading (the language is told by the parent directory, or -language if it selects one)",
+)
+
+var flagStream = flag.Bool(
+	"stream", false,
+	"build directly from the downloads without saving the data files (requires -db, -dsn or -format, not resumable)",
+)
+
+var flagStripPOS = flag.Bool(
+	"strip-pos", true,
+	"strip part-of-speech tags such as book_NOUN and _NOUN_ from tokens while building the db",
+)
+
+var flagSkipHeader = flag.Bool(
+	"skip-header", false,
+	"skip the first line of each data file if it is not an ngram record, such as a header row of a mirror",
+)
+
+var flagKeepBoundaries = flag.Bool(
+	"keep-boundaries", false,
+	"keep the sentence boundary tokens _START_ and _END_ while building the db instead of dropping the ngrams holding them",
+)
+
+var flagLowercase = flag.Bool(
+	"lowercase", false,
+	"merge tokens case-insensitively while building the db (uses more memory for merging)",
+)
+
+var flagShardByInitial = flag.Bool(
+	"shard-by-initial", false,
+	"split -db into a file per initial letter of the first token, such as ngrams_a.db ... ngrams_z.db and ngrams_other.db for ngrams.db",
+)
+
+var flagNormalizeVocab = flag.Bool(
+	"normalize-vocab", false,
+	"store each word once in a vocab (id, word) table of -db and the ngram words as its ids, which changes the schema",
+)
+
+var flagUnigramsOnly = flag.Bool(
+	"unigrams-only", false,
+	"download and build only the 1-grams, whatever -ngram selects, and add a words (word, count) view of them to -db or -dsn for dictionaries",
+)
+
+var flagTablePrefix = flag.String(
+	"table-prefix", "",
+	"prefix the tables of -db or -dsn as <prefix>_two_grams to share a database with other data",
+)
+
+var flagParseConcurrency = flag.Int(
+	"parse-concurrency", 1,
+	"number of downloaded files decompressed at once while building the db (each holds its merge map in memory, so the peak memory grows with it)",
+)
+
+var flagMaxMergeEntries = flag.Int(
+	"max-merge-entries", 0,
+	"insert the merge map of a file whenever it holds this many ngrams, bounding its memory; -db then upserts and -min-count applies per chunk (0 means unbounded)",
+)
+
+var flagConfig = flag.String("config", "", "JSON file of flag names and values, such as {\"language\": \"eng,fre\"}, overridden by the command line")
+
+var flagVersion = flag.Bool("version", false, "print the version and the VCS commit of this build and exit")
+
+var flagListLanguages = flag.Bool("list-languages", false, "print the valid languages one per line and exit")
+
+var flagListNgrams = flag.Bool("list-ngrams", false, "print the valid ngrams one per line and exit")
+
+var flagLogLevel = flag.String(
+	"log-level", "info",
+	"log level: "+strings.Join(validLogLevels, ", "),
+)
+
+var flagQuiet = flag.Bool("quiet", false, "only log warnings and errors, overriding -log-level and -progress")
+
+var flagLogFormat = flag.String(
+	"log-format", "text",
+	"log format: "+strings.Join(validLogFormats, ", "),
 )
 
 func main() {
-	if err := run(); err != nil {
-		log.Fatal(err)
+	var err error
+	switch {
+	case len(os.Args) > 1 && os.Args[1] == "merge":
+		err = runMerge(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "prune":
+		err = runPrune(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "complete":
+		err = runComplete(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "verify":
+		err = runVerify(os.Args[2:])
+	case len(os.Args) > 1 && os.Args[1] == "status":
+		err = runStatus(os.Args[2:])
+	default:
+		err = run()
+	}
+
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errDeadline):
+		slog.Warn(err.Error())
+	case errors.Is(err, mocword.ErrFilesFailed):
+		slog.Error(err.Error())
+		os.Exit(exitPartial)
+	case err != nil:
+		slog.Error(err.Error())
+		os.Exit(exitFatal)
 	}
 }
 
-func run() error {
-	if err := parseFlags(); err != nil {
+// The exit codes of mocword-download, which usage documents. A run stopped
+// at the -deadline exits with 0 since the next run resumes it.
+const (
+	// exitFatal is a failure of the run or of its flags.
+	exitFatal = 1
+
+	// exitPartial is a run which completed but could not download some data
+	// files, which were left out of the build. Running again retries them.
+	exitPartial = 2
+)
+
+// errDeadline tells that the run stopped early at the -deadline, which is not
+// a failure since the next run resumes it.
+var errDeadline = errors.New("stopped at the deadline")
+
+func run() (err error) {
+	err = parseFlags()
+	if *flagVersion {
+		fmt.Printf("mocword-download %s\n", mocword.ReadBuildInfo())
+		return nil
+	}
+	if *flagListLanguages || *flagListNgrams {
+		if *flagListLanguages {
+			printList(mocword.Languages)
+		}
+		if *flagListNgrams {
+			printList(mocword.Ngrams)
+		}
+		return nil
+	}
+	// The logger is set up even if other flags are invalid, so that the error
+	// is logged in the requested format.
+	if logger, lerr := newLogger(os.Stderr, logLevel(*flagLogLevel, *flagQuiet), *flagLogFormat); lerr == nil {
+		slog.SetDefault(logger)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The report is written even if the run fails, which it records.
+	var report *mocword.Report
+	if *flagReport != "" {
+		report = &mocword.Report{}
+		defer func() {
+			report.Finish(err)
+			if serr := report.Save(*flagReport); serr != nil {
+				slog.Error("cannot write report", "error", serr)
+				if err == nil {
+					err = serr
+				}
+			}
+		}()
+	}
+
+	httpClient, err := flagSource.client(*flagRateLimit)
+	if err != nil {
 		return err
 	}
 
-	url := downloadIndexURL("eng", "2")
-	body, _ := getHTML(url)
-	list, _ := dataURLList(body)
-	fmt.Println(strings.Join(list, "\n"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(ctx, cancel)
+	if *flagDeadline > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, *flagDeadline)
+		defer cancelDeadline()
+	}
+
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("%w after %v, run again to continue: %v", errDeadline, *flagDeadline, err)
+		}
+	}()
 
+	var metrics *mocword.Metrics
+	if *flagMetricsAddr != "" {
+		var stop func()
+		if metrics, stop, err = startMetricsServer(*flagMetricsAddr); err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	src := flagSource.source(httpClient, *flagLanguage, *flagNgram)
+	src.Retry = retryPolicyFromFlags()
+	src.Timeout = *flagTimeout
+	src.Strict = *flagStrict
+	src.MaxFiles = *flagMaxFiles
+	src.MaxIndexSize = *flagMaxIndexSize
+	src.Metrics = metrics
+
+	if *flagDryRun {
+		return dryRun(ctx, src)
+	}
+
+	if *flagCleanTmp {
+		for _, dir := range []string{*flagOutputDir, *flagTmpDir} {
+			if dir == "" {
+				continue
+			}
+			if err := mocword.CleanTempFiles(dir, *flagCleanTmpAge); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The files which cannot be downloaded are left out of the build, and the
+	// run then exits with exitPartial.
+	var dlErr error
+	if !*flagStream && *flagSourceDir == "" {
+		if dlErr = mocword.Download(ctx, downloadOptionsFromFlags(src, report)); dlErr != nil {
+			if errors.Is(dlErr, mocword.ErrNotEnoughSpace) {
+				return fmt.Errorf("%w (use -no-space-check to skip this check)", dlErr)
+			}
+			if !errors.Is(dlErr, mocword.ErrFilesFailed) {
+				return dlErr
+			}
+		}
+	}
+
+	bo := buildOptionsFromFlags(src)
+	bo.SkipMissing = dlErr != nil
+	// The missing files reported by Build are the ones of dlErr.
+	if err := mocword.Build(ctx, bo); err != nil && !(dlErr != nil && errors.Is(err, mocword.ErrFilesFailed)) {
+		return err
+	}
+	return dlErr
+}
+
+// printList prints each element of list on its own line.
+func printList(list []string) {
+	for _, elem := range list {
+		fmt.Println(elem)
+	}
+}
+
+// dryRun prints every data url of src to stdout and logs the total count.
+func dryRun(ctx context.Context, src mocword.Source) error {
+	urls, err := mocword.ListURLs(ctx, src)
+	if err != nil {
+		return err
+	}
+
+	for _, url := range urls {
+		fmt.Println(url)
+	}
+
+	slog.Info("dry run", "urls", len(urls))
 	return nil
 }
 
+func downloadOptionsFromFlags(src mocword.Source, report *mocword.Report) mocword.DownloadOptions {
+	return mocword.DownloadOptions{
+		Source:           src,
+		Dir:              *flagOutputDir,
+		TmpDir:           *flagTmpDir,
+		ChecksumFile:     *flagChecksumFile,
+		WriteChecksums:   *flagWriteChecksums,
+		VerifyGzip:       *flagVerifyGzip,
+		Decompress:       *flagDecompress,
+		Verify:           *flagVerify,
+		Force:            *flagForce,
+		Report:           report,
+		NoSpaceCheck:     *flagNoSpaceCheck,
+		Progress:         *flagProgress && !*flagQuiet,
+		ProgressInterval: *flagProgressInterval,
+	}
+}
+
+func buildOptionsFromFlags(src mocword.Source) mocword.BuildOptions {
+	delim, _ := parseCSVDelim(*flagCSVDelim)
+
+	return mocword.BuildOptions{
+		Source:            src,
+		Dir:               *flagOutputDir,
+		SourceDir:         *flagSourceDir,
+		Stream:            *flagStream,
+		DB:                *flagDB,
+		DSN:               *flagDSN,
+		Format:            *flagFormat,
+		Export:            *flagExport,
+		CSVDelim:          delim,
+		Compress:          *flagCompress,
+		CompressLevel:     *flagCompressLevel,
+		BatchSize:         *flagBatchSize,
+		MinCount:          *flagMinCount,
+		YearStart:         *flagYearStart,
+		YearEnd:           *flagYearEnd,
+		SkipIndex:         *flagSkipIndex,
+		Optimize:          *flagOptimize,
+		StripPOS:          *flagStripPOS,
+		KeepBoundaries:    *flagKeepBoundaries,
+		SkipHeader:        *flagSkipHeader,
+		Lowercase:         *flagLowercase,
+		ShardByInitial:    *flagShardByInitial,
+		NormalizeVocab:    *flagNormalizeVocab,
+		TablePrefix:       *flagTablePrefix,
+		UnigramsOnly:      *flagUnigramsOnly,
+		ParseConcurrency:  *flagParseConcurrency,
+		MaxMergeEntries:   *flagMaxMergeEntries,
+		Deterministic:     *flagDeterministic,
+		Force:             *flagForce,
+		SafeMode:          *flagSafeMode,
+		SQLiteSynchronous: *flagSQLiteSynchronous,
+		SQLiteCacheSize:   *flagSQLiteCacheSize,
+		Progress:          *flagProgress && !*flagQuiet,
+		ProgressInterval:  *flagProgressInterval,
+	}
+}
+
+const forceExitWindow = 5 * time.Second
+
+// handleSignals cancels ctx on SIGINT or SIGTERM so that running downloads can
+// unwind through their cleanup. A second signal within forceExitWindow exits
+// immediately.
+func handleSignals(ctx context.Context, cancel context.CancelFunc) {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(sigCh)
+
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigCh:
+			slog.Warn("shutting down", "signal", sig)
+			cancel()
+		}
+
+		select {
+		case sig := <-sigCh:
+			slog.Warn("exiting", "signal", sig)
+			os.Exit(1)
+		case <-time.After(forceExitWindow):
+		}
+	}()
+}
+
+// usage prints the help of the main command with its flags and exit codes.
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "usage: %s [flags]\n", os.Args[0])
+	fmt.Fprintf(w, "       %s merge|prune|complete|verify|status [flags] ...\n\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(w, "\nexit status:\n")
+	fmt.Fprintf(w, "  0\tthe run completed, or stopped at -deadline (run again to continue)\n")
+	fmt.Fprintf(w, "  %d\tthe run or its flags failed\n", exitFatal)
+	fmt.Fprintf(w, "  %d\tthe run completed, but some data files could not be downloaded and were left out of the build (run again to retry them)\n", exitPartial)
+}
+
 func parseFlags() error {
-	flag.Parse()
+	// A flag error exits with exitFatal as the other errors instead of the 2
+	// of flag.ExitOnError.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.Usage = usage
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		return err
+	}
+	if *flagConfig != "" {
+		if err := loadConfig(flag.CommandLine, *flagConfig, nil); err != nil {
+			return fmt.Errorf("cannot parse flags: %w", err)
+		}
+	}
+	if err := normalizeFlags(); err != nil {
+		return fmt.Errorf("cannot parse flags: %w", err)
+	}
 	if err := verifyFlags(); err != nil {
 		return fmt.Errorf("cannot parse flags: %w", err)
 	}
 	return nil
 }
 
+func normalizeFlags() error {
+	lang, err := expandFlagAll(canonicalFlagList(*flagLanguage, mocword.Languages), mocword.Languages)
+	if err != nil {
+		return fmt.Errorf("invalid language flag: %w", err)
+	}
+	*flagLanguage = lang
+
+	ngram, err := expandFlagAll(canonicalFlagList(*flagNgram, mocword.Ngrams), mocword.Ngrams)
+	if err != nil {
+		return fmt.Errorf("invalid ngram flag: %w", err)
+	}
+	*flagNgram = ngram
+	if *flagUnigramsOnly {
+		*flagNgram = "1"
+	}
+
+	return nil
+}
+
+// splitFlagList splits a comma separated flag into its trimmed and
+// lowercased elements, ignoring empty ones such as of a trailing comma.
+func splitFlagList(rawFlag string) []string {
+	var flags []string
+	for _, flg := range strings.Split(rawFlag, ",") {
+		flg = strings.ToLower(strings.TrimSpace(flg))
+		if flg != "" {
+			flags = append(flags, flg)
+		}
+	}
+	return flags
+}
+
+// canonicalFlagList rewrites the elements of a comma separated flag in the
+// spelling of validFlags, keeping the unknown ones to be reported by
+// verifyFlags.
+func canonicalFlagList(rawFlag string, validFlags []string) string {
+	flags := splitFlagList(rawFlag)
+	for i, flg := range flags {
+		for _, validFlg := range validFlags {
+			if sameFlagElement(flg, validFlg) {
+				flags[i] = validFlg
+				break
+			}
+		}
+	}
+	return strings.Join(flags, ",")
+}
+
+// sameFlagElement tells whether a flag element is valid ignoring the case and
+// "-" or "_", which the language codes mix up as "eng-us" and "chi_sim".
+func sameFlagElement(flg, valid string) bool {
+	return strings.EqualFold(strings.ReplaceAll(flg, "_", "-"), strings.ReplaceAll(valid, "_", "-"))
+}
+
+// expandFlagAll replaces "all" with every valid element. "all" cannot be
+// mixed with other elements.
+func expandFlagAll(rawFlag string, validFlags []string) (string, error) {
+	flags := strings.Split(rawFlag, ",")
+
+	for _, flg := range flags {
+		if flg != "all" {
+			continue
+		}
+		if len(flags) > 1 {
+			return "", fmt.Errorf("\"all\" cannot be combined with other values: %q", rawFlag)
+		}
+		return strings.Join(validFlags, ","), nil
+	}
+
+	return rawFlag, nil
+}
+
 func verifyFlags() error {
 	if err := verifyFlagLanguage(*flagLanguage); err != nil {
 		return fmt.Errorf("invalid flag: %w", err)
@@ -80,128 +622,286 @@ func verifyFlags() error {
 		return fmt.Errorf("invalid flag: %w", err)
 	}
 
+	if err := flagSource.verify(); err != nil {
+		return err
+	}
+
+	if *flagDB != "" && *flagDSN != "" {
+		return errors.New("invalid flag: -db and -dsn are exclusive")
+	}
+
+	if err := verifyFlagFormat(*flagFormat); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if err := verifyFlagCompress(*flagCompress, *flagCompressLevel); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if _, err := parseCSVDelim(*flagCSVDelim); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if *flagStream && *flagDB == "" && *flagDSN == "" && *flagFormat == "" {
+		return errors.New("invalid flag: -stream requires -db, -dsn or -format")
+	}
+
+	if *flagSourceDir != "" && *flagStream {
+		return errors.New("invalid flag: -source-dir and -stream are exclusive")
+	}
+
+	if *flagSourceDir != "" && *flagDB == "" && *flagDSN == "" && *flagFormat == "" {
+		return errors.New("invalid flag: -source-dir requires -db, -dsn or -format")
+	}
+
+	if err := verifyFlagSQLiteSynchronous(*flagSQLiteSynchronous); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if err := verifyFlagYearRange(*flagYearStart, *flagYearEnd); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if *flagShardByInitial && *flagDB == "" {
+		return errors.New("invalid flag: -shard-by-initial requires -db")
+	}
+
+	if *flagNormalizeVocab && *flagDB == "" {
+		return errors.New("invalid flag: -normalize-vocab requires -db")
+	}
+
+	if err := verifyFlagRetry(*flagMaxRetries, *flagRetryBaseDelay, *flagRetryMaxDelay, *flagRetryJitter); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+	if *flagMaxRetryAfter <= 0 {
+		return fmt.Errorf("invalid flag: invalid max retry after flag: %v", *flagMaxRetryAfter)
+	}
+
+	if *flagForce && (*flagDSN != "" || *flagShardByInitial) {
+		return errors.New("invalid flag: -force cannot be used with -dsn or -shard-by-initial")
+	}
+
+	if *flagUnigramsOnly && *flagDB == "" && *flagDSN == "" {
+		return errors.New("invalid flag: -unigrams-only requires -db or -dsn")
+	}
+	if *flagUnigramsOnly && *flagShardByInitial {
+		return errors.New("invalid flag: -unigrams-only and -shard-by-initial are exclusive")
+	}
+
+	if *flagTablePrefix != "" && *flagDB == "" && *flagDSN == "" {
+		return errors.New("invalid flag: -table-prefix requires -db or -dsn")
+	}
+	if err := verifyFlagTablePrefix(*flagTablePrefix); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if *flagMaxMergeEntries < 0 {
+		return fmt.Errorf("invalid flag: invalid max merge entries flag: %d", *flagMaxMergeEntries)
+	}
+	if *flagMaxMergeEntries > 0 && *flagDB == "" {
+		return errors.New("invalid flag: -max-merge-entries requires -db")
+	}
+	if *flagMaxMergeEntries > 0 && *flagStream {
+		return errors.New("invalid flag: -max-merge-entries and -stream are exclusive")
+	}
+
+	if *flagMaxIndexSize < 1 {
+		return fmt.Errorf("invalid flag: invalid max index size flag: %d", *flagMaxIndexSize)
+	}
+
+	if *flagParseConcurrency < 1 {
+		return fmt.Errorf("invalid flag: invalid parse concurrency flag: %d", *flagParseConcurrency)
+	}
+
+	if _, err := newLogger(io.Discard, *flagLogLevel, *flagLogFormat); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
 	return nil
 }
 
 func verifyFlagLanguage(flg string) error {
-	if invalid := findInvalidFlagElement(flg, validLanguages); invalid != "" {
-		return fmt.Errorf("invalid language flag: %q", invalid)
+	if len(splitFlagList(flg)) == 0 {
+		return errors.New("invalid language flag: no language")
+	}
+	if invalid := findInvalidFlagElements(flg, mocword.Languages); len(invalid) > 0 {
+		return fmt.Errorf("invalid language flag: %s", quoteList(invalid))
 	}
 	return nil
 }
 
 func verifyFlagNgram(flg string) error {
-	if invalid := findInvalidFlagElement(flg, validNgrams); invalid != "" {
-		return fmt.Errorf("invalid ngram flag: %q", invalid)
+	if len(splitFlagList(flg)) == 0 {
+		return errors.New("invalid ngram flag: no ngram")
+	}
+	if invalid := findInvalidFlagElements(flg, mocword.Ngrams); len(invalid) > 0 {
+		return fmt.Errorf("invalid ngram flag: %s", quoteList(invalid))
 	}
 	return nil
 }
 
-func findInvalidFlagElement(rawFlag string, validFlags []string) string {
-	flags := strings.Split(rawFlag, ",")
+func verifyFlagDatasetVersion(flg string) error {
+	if flg == "latest" {
+		return nil
+	}
+	if len(flg) != 8 {
+		return fmt.Errorf("invalid dataset version flag: %q", flg)
+	}
+	if _, err := time.Parse("20060102", flg); err != nil {
+		return fmt.Errorf("invalid dataset version flag: %q", flg)
+	}
+	return nil
+}
 
-	for _, flg := range flags {
-		found := false
-		for _, validFlg := range validFlags {
-			found = found || flg == validFlg
-		}
+// verifyFlagCompress verifies -compress and its level, which is up to 9 for
+// gzip, told by the extension of -export if flg is empty.
+func verifyFlagCompress(flg string, level int) error {
+	valid := flg == ""
+	for _, c := range mocword.Compressions {
+		valid = valid || flg == c
+	}
+	if !valid {
+		return fmt.Errorf("invalid compress flag: %q", flg)
+	}
 
-		if !found {
-			return flg
-		}
+	gzipped := flg == "gzip" || flg == "" && strings.HasSuffix(*flagExport, ".gz")
+	if level < 0 || level > 22 || gzipped && level > 9 {
+		return fmt.Errorf("invalid compress level flag: %d", level)
 	}
+	return nil
+}
 
-	return ""
+func verifyFlagFormat(flg string) error {
+	if flg == "" {
+		return nil
+	}
+	for _, valid := range mocword.Formats {
+		if flg == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid format flag: %q", flg)
 }
 
-func totalCountsURL(lang, ngram string) string {
-	return fmt.Sprintf("http://storage.googleapis.com/books/ngrams/books/20200217/%s/totalcounts-%s", lang, ngram)
+func verifyFlagSQLiteSynchronous(flg string) error {
+	for _, valid := range mocword.SynchronousModes {
+		if strings.ToUpper(flg) == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid sqlite synchronous flag: %q", flg)
 }
 
-func downloadIndexURL(lang, ngram string) string {
-	return fmt.Sprintf("http://storage.googleapis.com/books/ngrams/books/20200217/%s/%s-%s-ngrams_exports.html", lang, lang, ngram)
+func verifyFlagHTTPProtocol(flg string) error {
+	for _, valid := range httpProtocols {
+		if flg == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid http protocol flag: %q", flg)
 }
 
-func getHTML(url string) (body string, err error) {
-	res, err := http.Get(url)
+func verifyFlagBaseURL(flg string, insecure bool) error {
+	u, err := url.Parse(flg)
 	if err != nil {
-		return
+		return fmt.Errorf("invalid base url flag: %w", err)
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		err = fmt.Errorf("cannot get %s", url)
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid base url flag: not an absolute url: %q", flg)
+	}
+	if u.Scheme == "http" && !insecure {
+		return fmt.Errorf("invalid base url flag: plaintext http requires -insecure: %q", flg)
 	}
+	return nil
+}
 
-	buf, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return
+func verifyFlagYearRange(start, end int) error {
+	if start > end {
+		return fmt.Errorf("invalid year range flag: start %d is after end %d", start, end)
 	}
-	if !utf8.Valid(buf) {
-		err = fmt.Errorf("non-unicode HTML: %s", url)
+	return nil
+}
+
+func verifyFlagTablePrefix(flg string) error {
+	if flg != "" && !mocword.ValidTablePrefix(flg) {
+		return fmt.Errorf("invalid table prefix flag: %q (lowercase letters, digits and underscores, not starting with a digit, up to 39)", flg)
 	}
-	body = string(buf)
+	return nil
+}
 
-	return
+func verifyFlagRetry(maxRetries int, base, maxDelay time.Duration, jitter float64) error {
+	if maxRetries < 0 {
+		return fmt.Errorf("invalid max retries flag: %d", maxRetries)
+	}
+	if base <= 0 {
+		return fmt.Errorf("invalid retry base delay flag: %v", base)
+	}
+	if maxDelay < base {
+		return fmt.Errorf("invalid retry max delay flag: %v is less than the base delay %v", maxDelay, base)
+	}
+	if jitter < 0 || jitter > 1 {
+		return fmt.Errorf("invalid retry jitter flag: %v", jitter)
+	}
+	return nil
 }
 
-func dataURLList(body string) (urls []string, err error) {
-	r := strings.NewReader(body)
-	doc, err := goquery.NewDocumentFromReader(r)
-	if err != nil {
-		err = fmt.Errorf("cannot get data urls: %w", err)
-		return
+func retryPolicyFromFlags() mocword.RetryPolicy {
+	return mocword.RetryPolicy{
+		BaseDelay:     *flagRetryBaseDelay,
+		MaxDelay:      *flagRetryMaxDelay,
+		MaxAttempts:   *flagMaxRetries + 1,
+		Jitter:        *flagRetryJitter,
+		MaxRetryAfter: *flagMaxRetryAfter,
 	}
+}
 
-	doc.Find("li").Each(func(_ int, s *goquery.Selection) {
-		url, ok := s.Find("a").Attr("href")
-		if !ok {
-			err = fmt.Errorf("cannot get data urls: invalid attr: %s", s.Find("a").Text())
-			return
+// findInvalidFlagElements returns every element of a comma separated flag
+// which is not in validFlags, in order.
+func findInvalidFlagElements(rawFlag string, validFlags []string) []string {
+	var invalid []string
+
+	for _, flg := range splitFlagList(rawFlag) {
+		found := false
+		for _, validFlg := range validFlags {
+			found = found || sameFlagElement(flg, validFlg)
 		}
 
-		urls = append(urls, url)
-	})
+		if !found {
+			invalid = append(invalid, flg)
+		}
+	}
 
-	return
+	return invalid
 }
 
-func do(ctx context.Context, url, dir string) error {
-	done := false
-	fname := path.Base(url)
-	absFname := filepath.Join(dir, fname)
-
-	if _, err := os.Stat(absFname); os.IsExist(err) {
-		return nil
+// quoteList quotes and joins elems for an error message.
+func quoteList(elems []string) string {
+	quoted := make([]string, len(elems))
+	for i, elem := range elems {
+		quoted[i] = strconv.Quote(elem)
 	}
+	return strings.Join(quoted, ", ")
+}
 
-	tmpfile, err := ioutil.TempFile(dir, fname)
-	if err != nil {
-		return fmt.Errorf("do error: %w")
-	}
-	defer func() {
-		if !done {
-			os.Remove(tmpfile.Name())
-		}
-		os.Rename(tmpfile.Name(), absFname)
-	}()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("do error: %w", err)
+// baseURL returns the base url to use. With insecure, Google's storage is
+// accessed over plaintext http.
+func baseURL(flg string, insecure bool) string {
+	if insecure && flg == mocword.DefaultBaseURL {
+		return "http://" + strings.TrimPrefix(flg, "https://")
 	}
+	return flg
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("do error: %w", err)
+// parseCSVDelim parses the -csv-delim flag. `\t` and "tab" mean a tab.
+func parseCSVDelim(flg string) (rune, error) {
+	switch flg {
+	case `\t`, "tab":
+		return '\t', nil
 	}
-	defer resp.Body.Close()
 
-	r := bufio.NewReader(resp.Body)
-	gr, err := gzip.NewReader(r)
-	if err != nil {
-		return err
+	r, size := utf8.DecodeRuneInString(flg)
+	if size == 0 || size != len(flg) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid csv delimiter flag: %q", flg)
 	}
-	defer gr.Close()
-
+	return r, nil
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+func TestVerifyFlagDatasetVersion(t *testing.T) {
+	tests := []struct {
+		flg   string
+		valid bool
+	}{
+		{"20200217", true},
+		{"20120701", true},
+		{"latest", true},
+		{"2020021", false},
+		{"202002170", false},
+		{"2020-02-17", false},
+		{"20201317", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if err := verifyFlagDatasetVersion(tt.flg); (err == nil) != tt.valid {
+			t.Errorf("verifyFlagDatasetVersion(%q) = %v, want valid %v", tt.flg, err, tt.valid)
+		}
+	}
+}
+
+func TestCanonicalFlagList(t *testing.T) {
+	tests := []struct {
+		flg  string
+		want string
+	}{
+		{"eng", "eng"},
+		{"ENG", "eng"},
+		{"eng, fre", "eng,fre"},
+		{" Eng-US ,chi-sim", "eng-us,chi_sim"},
+		{"eng,fre,", "eng,fre"},
+		{"eng,,fre", "eng,fre"},
+		{"klingon", "klingon"},
+	}
+
+	for _, tt := range tests {
+		got := canonicalFlagList(tt.flg, mocword.Languages)
+		if got != tt.want {
+			t.Errorf("canonicalFlagList(%q) = %q, want %q", tt.flg, got, tt.want)
+		}
+		if err := verifyFlagLanguage(got); (err == nil) != (tt.flg != "klingon") {
+			t.Errorf("verifyFlagLanguage(%q) = %v", got, err)
+		}
+	}
+}
+
+func TestVerifyFlagLanguageInvalid(t *testing.T) {
+	err := verifyFlagLanguage("eng,klingon,fre,elvish")
+	if err == nil {
+		t.Fatal("verifyFlagLanguage() = nil, want an error")
+	}
+	for _, bad := range []string{`"klingon"`, `"elvish"`} {
+		if !strings.Contains(err.Error(), bad) {
+			t.Errorf("verifyFlagLanguage() = %v, want it to tell %s", err, bad)
+		}
+	}
+	if strings.Contains(err.Error(), `"eng"`) || strings.Contains(err.Error(), `"fre"`) {
+		t.Errorf("verifyFlagLanguage() = %v, which tells valid languages", err)
+	}
+}
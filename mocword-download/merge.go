@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+// runMerge runs the merge subcommand:
+//
+//	mocword-download merge -o merged.db eng.db fre.db ...
+func runMerge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mocword-download merge -o OUTPUT [flags] SOURCE...")
+		fs.PrintDefaults()
+	}
+
+	output := fs.String("o", "", "path of the SQLite database to merge the sources into")
+	normalizeVocab := fs.Bool("normalize-vocab", false, "store the words of the output as ids of a vocab (id, word) table")
+	skipIndex := fs.Bool("skip-index", false, "do not create indexes after merging")
+	batchSize := fs.Int("batch-size", 10000, "number of rows inserted per transaction")
+	tablePrefix := fs.String("table-prefix", "", "merge the tables prefixed as <prefix>_two_grams by the -table-prefix of the build")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return errors.New("cannot parse flags: invalid flag: -o is required")
+	}
+	if fs.NArg() == 0 {
+		return errors.New("cannot parse flags: no source database")
+	}
+	if err := verifyFlagTablePrefix(*tablePrefix); err != nil {
+		return fmt.Errorf("cannot parse flags: invalid flag: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(ctx, cancel)
+
+	for _, src := range fs.Args() {
+		if _, err := os.Stat(src); err != nil {
+			return fmt.Errorf("cannot merge: %w", err)
+		}
+	}
+
+	return mocword.Merge(ctx, mocword.MergeOptions{
+		Sources:        fs.Args(),
+		Output:         *output,
+		NormalizeVocab: *normalizeVocab,
+		SkipIndex:      *skipIndex,
+		BatchSize:      *batchSize,
+		TablePrefix:    *tablePrefix,
+	})
+}
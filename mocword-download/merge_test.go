@@ -0,0 +1,78 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestDB writes the 1-grams of counts into the table of 1-grams of the
+// SQLite database fname.
+func writeTestDB(t *testing.T, fname, table string, counts map[string]int64) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE " + table + " (word1 TEXT NOT NULL, count INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	for word, count := range counts {
+		if _, err := db.Exec("INSERT INTO "+table+" (word1, count) VALUES (?, ?)", word, count); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTestDB returns the 1-gram counts of the table of the SQLite database
+// fname.
+func readTestDB(t *testing.T, fname, table string) map[string]int64 {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT word1, count FROM " + table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var word string
+		var count int64
+		if err := rows.Scan(&word, &count); err != nil {
+			t.Fatal(err)
+		}
+		counts[word] += count
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func TestRunMergeTablePrefix(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.db")
+	out := filepath.Join(dir, "out.db")
+	writeTestDB(t, src, "p_one_grams", map[string]int64{"apple": 3})
+
+	if err := runMerge([]string{"-table-prefix", "p", "-o", out, src}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestDB(t, out, "p_one_grams"); len(got) != 1 || got["apple"] != 3 {
+		t.Errorf("merged p_one_grams = %v, want map[apple:3]", got)
+	}
+
+	if err := runMerge([]string{"-table-prefix", "Bad-Prefix", "-o", out, src}); err == nil {
+		t.Error("merge -table-prefix Bad-Prefix succeeded, want an error")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+// runPrune runs the prune subcommand:
+//
+//	mocword-download prune -min-count 40 ngrams.db
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mocword-download prune -min-count N DB")
+		fs.PrintDefaults()
+	}
+
+	minCount := fs.Int64("min-count", 0, "delete the ngrams whose count is below this")
+	tablePrefix := fs.String("table-prefix", "", "prune the tables prefixed as <prefix>_two_grams by the -table-prefix of the build")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *minCount < 1 {
+		return errors.New("cannot parse flags: invalid flag: -min-count must be positive")
+	}
+	if fs.NArg() != 1 {
+		return errors.New("cannot parse flags: want one database")
+	}
+	if err := verifyFlagTablePrefix(*tablePrefix); err != nil {
+		return fmt.Errorf("cannot parse flags: invalid flag: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(ctx, cancel)
+
+	return mocword.Prune(ctx, mocword.PruneOptions{
+		DB:          fs.Arg(0),
+		MinCount:    *minCount,
+		TablePrefix: *tablePrefix,
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRunPruneTablePrefix(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "ngrams.db")
+	writeTestDB(t, db, "p_one_grams", map[string]int64{"apple": 1, "banana": 5})
+
+	if err := runPrune([]string{"-table-prefix", "p", "-min-count", "2", db}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestDB(t, db, "p_one_grams"); len(got) != 1 || got["banana"] != 5 {
+		t.Errorf("pruned p_one_grams = %v, want map[banana:5]", got)
+	}
+
+	if err := runPrune([]string{"-table-prefix", "Bad-Prefix", "-min-count", "2", db}); err == nil {
+		t.Error("prune -table-prefix Bad-Prefix succeeded, want an error")
+	}
+}
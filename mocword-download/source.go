@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+// sourceFlags are the flags of the dataset storage and of its HTTP client,
+// which the status subcommand shares with the main command.
+type sourceFlags struct {
+	datasetVersion *string
+	baseURL        *string
+	insecure       *bool
+	headers        headerFlag
+	proxy          *string
+	maxIdleConns   *int
+	httpProtocol   *string
+}
+
+// addSourceFlags defines the sourceFlags in fs.
+func addSourceFlags(fs *flag.FlagSet) *sourceFlags {
+	f := &sourceFlags{
+		datasetVersion: fs.String(
+			"dataset-version", mocword.DefaultDatasetVersion,
+			"Google Books Ngram dataset version (release date as YYYYMMDD, or \"latest\")",
+		),
+		baseURL: fs.String(
+			"base-url", mocword.DefaultBaseURL,
+			"base url of the dataset storage, to use a mirror (\"latest\" dataset version is only discovered on Google's storage)",
+		),
+		insecure: fs.Bool(
+			"insecure", false,
+			"use plaintext http for Google's storage and allow an http -base-url",
+		),
+		proxy: fs.String(
+			"proxy", "",
+			"proxy url for every request; overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY which are used otherwise",
+		),
+		maxIdleConns: fs.Int("max-idle-conns", 16, "max number of idle connections kept per host for reuse"),
+		httpProtocol: fs.String(
+			"http-protocol", "auto",
+			"HTTP protocol: auto (HTTP/2 if the server supports it), http1 or http2",
+		),
+	}
+	fs.Var(&f.headers, "header", "extra \"Key: Value\" HTTP header sent with every request; repeatable (e.g. \"User-Agent: ...\" or \"Authorization: ...\")")
+	return f
+}
+
+func (f *sourceFlags) verify() error {
+	if err := verifyFlagDatasetVersion(*f.datasetVersion); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if err := verifyFlagBaseURL(*f.baseURL, *f.insecure); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	if *f.proxy != "" {
+		if _, err := parseProxyURL(*f.proxy); err != nil {
+			return fmt.Errorf("invalid flag: %w", err)
+		}
+	}
+
+	if *f.maxIdleConns < 1 {
+		return fmt.Errorf("invalid flag: invalid max idle conns flag: %d", *f.maxIdleConns)
+	}
+	if err := verifyFlagHTTPProtocol(*f.httpProtocol); err != nil {
+		return fmt.Errorf("invalid flag: %w", err)
+	}
+
+	return nil
+}
+
+// client returns the client of the flags, throttled to rateLimit bytes/sec if
+// positive.
+func (f *sourceFlags) client(rateLimit int) (mocword.HTTPClient, error) {
+	return newSourceClient(*f.proxy, *f.maxIdleConns, *f.httpProtocol, f.headers.header, rateLimit)
+}
+
+// source returns the Source of the comma separated langs and ngrams of the
+// storage of the flags.
+func (f *sourceFlags) source(client mocword.HTTPClient, langs, ngrams string) mocword.Source {
+	return mocword.Source{
+		Client:         client,
+		BaseURL:        baseURL(*f.baseURL, *f.insecure),
+		DatasetVersion: *f.datasetVersion,
+		Languages:      strings.Split(langs, ","),
+		Ngrams:         strings.Split(ngrams, ","),
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+// runStatus runs the status subcommand, which prints a matrix of the
+// languages and ngrams of a download directory with the downloaded and listed
+// files of each, such as "12/24", or "-" if the language does not publish the
+// ngram:
+//
+//	mocword-download status -language eng,fre corpus
+func runStatus(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mocword-download status [flags] DIR")
+		fs.PrintDefaults()
+	}
+
+	language := fs.String("language", "all", "comma separated language names, or \"all\"")
+	ngram := fs.String("ngram", "all", "comma separated ngram number, or \"all\"")
+	built := fs.Bool("built", false, "also print the files recorded as built into a database as downloaded/built/listed")
+	config := fs.String("config", "", "JSON file of flag names and values as the one of the main command, whose other flags are ignored")
+	sf := addSourceFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *config != "" {
+		if err := loadConfig(fs, *config, flag.CommandLine); err != nil {
+			return fmt.Errorf("cannot parse flags: %w", err)
+		}
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("cannot parse flags: want one directory")
+	}
+
+	langs, err := expandFlagAll(canonicalFlagList(*language, mocword.Languages), mocword.Languages)
+	if err != nil {
+		return fmt.Errorf("cannot parse flags: invalid language flag: %w", err)
+	}
+	ngrams, err := expandFlagAll(canonicalFlagList(*ngram, mocword.Ngrams), mocword.Ngrams)
+	if err != nil {
+		return fmt.Errorf("cannot parse flags: invalid ngram flag: %w", err)
+	}
+	if err := verifyFlagLanguage(langs); err != nil {
+		return fmt.Errorf("cannot parse flags: invalid flag: %w", err)
+	}
+	if err := verifyFlagNgram(ngrams); err != nil {
+		return fmt.Errorf("cannot parse flags: invalid flag: %w", err)
+	}
+	if err := sf.verify(); err != nil {
+		return fmt.Errorf("cannot parse flags: %w", err)
+	}
+
+	client, err := sf.client(0)
+	if err != nil {
+		return fmt.Errorf("cannot parse flags: invalid flag: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(ctx, cancel)
+
+	src := sf.source(client, langs, ngrams)
+	statuses, err := mocword.DownloadStatus(ctx, src, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	cells := make(map[[2]string]string)
+	for _, s := range statuses {
+		cell := fmt.Sprintf("%d/%d", s.Downloaded, s.Files)
+		if *built {
+			cell = fmt.Sprintf("%d/%d/%d", s.Downloaded, s.Built, s.Files)
+		}
+		cells[[2]string{s.Language, s.Ngram}] = cell
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "language\t%s\n", strings.Join(src.Ngrams, "\t"))
+	for _, lang := range src.Languages {
+		row := []string{lang}
+		for _, n := range src.Ngrams {
+			cell, ok := cells[[2]string{lang, n}]
+			if !ok {
+				cell = "-"
+			}
+			row = append(row, cell)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
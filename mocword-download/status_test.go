@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+func TestRunStatusClient(t *testing.T) {
+	var mu sync.Mutex
+	var agents, tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.Header.Get("User-Agent"))
+		tokens = append(tokens, r.Header.Get("X-Token"))
+		mu.Unlock()
+
+		if r.URL.Path != "/"+mocword.DefaultDatasetVersion+"/eng/eng-1-ngrams_exports.html" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<ul><li><a href="1-00000-of-00001.gz">1-00000-of-00001.gz</a></li></ul>`))
+	}))
+	defer srv.Close()
+
+	err := runStatus([]string{
+		"-language", "eng", "-ngram", "1", "-base-url", srv.URL, "-insecure",
+		"-header", "X-Token: secret",
+		t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(agents) == 0 {
+		t.Fatal("status sent no request")
+	}
+	for i := range agents {
+		if agents[i] != defaultUserAgent {
+			t.Errorf("request %d: User-Agent = %q, want %q", i, agents[i], defaultUserAgent)
+		}
+		if tokens[i] != "secret" {
+			t.Errorf("request %d: X-Token = %q, want %q", i, tokens[i], "secret")
+		}
+	}
+}
+
+func TestRunStatusFlags(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+mocword.DefaultDatasetVersion+"/eng/eng-1-ngrams_exports.html" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<ul><li><a href="1-00000-of-00001.gz">1-00000-of-00001.gz</a></li></ul>`))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"-base-url", srv.URL},
+		{"-base-url", srv.URL, "-insecure", "-dataset-version", "2020"},
+		{"-base-url", srv.URL, "-insecure", "-rate-limit", "1000"},
+	} {
+		args = append([]string{"-language", "eng", "-ngram", "1"}, append(args, dir)...)
+		if err := runStatus(args); err == nil {
+			t.Errorf("runStatus(%q) succeeded, want a flag error", args)
+		}
+	}
+
+	// The flags of the main command in the config are ignored.
+	config := filepath.Join(t.TempDir(), "config.json")
+	body := `{"base-url": "` + srv.URL + `", "insecure": true, "language": "eng", "ngram": "1", "db": "ngrams.db"}`
+	if err := os.WriteFile(config, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := runStatus([]string{"-config", config, dir}); err != nil {
+		t.Errorf("runStatus() with a config: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/high-moctane/mocword-dataset-generator/mocword"
+)
+
+// runVerify runs the verify subcommand, which checks the files of a corpus
+// against their checksums without downloading:
+//
+//	mocword-download verify -checksum-file checksums.txt corpus
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mocword-download verify [flags] DIR")
+		fs.PrintDefaults()
+	}
+
+	checksumFile := fs.String("checksum-file", "", "file of \"<sha256>  <url>\" lines to verify against (default DIR/checksums.txt)")
+	concurrency := fs.Int("concurrency", runtime.NumCPU(), "number of files hashed at once")
+	progress := fs.Bool("progress", true, "report the hashed files and bytes")
+	progressInterval := fs.Duration("progress-interval", 10*time.Second, "interval of the progress logs")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("cannot parse flags: want one directory")
+	}
+	if *concurrency < 1 {
+		return fmt.Errorf("cannot parse flags: invalid flag: invalid concurrency flag: %d", *concurrency)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleSignals(ctx, cancel)
+
+	return mocword.VerifyCorpus(ctx, mocword.VerifyOptions{
+		Dir:              fs.Arg(0),
+		ChecksumFile:     *checksumFile,
+		Concurrency:      *concurrency,
+		Progress:         *progress,
+		ProgressInterval: *progressInterval,
+	})
+}
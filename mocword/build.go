@@ -0,0 +1,515 @@
+package mocword
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildOptions configures how ngram files are loaded into the store.
+type buildOptions struct {
+	minCount  int64
+	yearStart int
+	yearEnd   int
+	stripPOS  bool
+
+	// keepBoundaries keeps the sentence boundary tokens, which are stripped
+	// with the n-grams holding them otherwise.
+	keepBoundaries bool
+
+	// lowercase folds the case of tokens before aggregation, so that "The"
+	// and "the" are counted as one n-gram. Note that the in-flight merge map
+	// then holds the folded variants of the whole file.
+	lowercase bool
+
+	// parseConcurrency is the number of files decompressed and aggregated at
+	// once by build. Each of them holds its own merge map until inserted.
+	parseConcurrency int
+
+	// metrics counts the built files and rows of the current combo if not
+	// nil.
+	metrics *comboMetrics
+
+	// deterministic inserts the n-grams of a file sorted by their tokens
+	// into every store.
+	deterministic bool
+
+	// progress counts the inserted rows and parsed bytes if not nil.
+	progress *buildProgress
+
+	// skipHeader skips the first line of a file if it is not an n-gram.
+	skipHeader bool
+
+	// maxMergeEntries bounds the entries of a merge map if positive, which is
+	// then inserted and reset whenever it reaches the bound.
+	maxMergeEntries int
+}
+
+// build inserts the downloaded ngram files of urls in dir into store. Files
+// recorded as built in m are skipped.
+//
+// Up to opts.parseConcurrency files are decompressed and aggregated
+// concurrently, each into its own map, while the aggregated files are inserted
+// one by one in the order of urls since store is not safe for concurrent use.
+// With opts.maxMergeEntries, the map of a file is sent to be inserted in
+// chunks.
+func build(ctx context.Context, store Store, ngram, dir string, urls []string, opts buildOptions, m *manifest) error {
+	n, err := strconv.Atoi(ngram)
+	if err != nil {
+		return fmt.Errorf("cannot build: invalid ngram: %q", ngram)
+	}
+
+	pending, err := pendingSources(ctx, store, urls, m)
+	if err != nil {
+		return err
+	}
+
+	concurrency := opts.parseConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// A slot of sem is held from the start of the parse of a file until its
+	// insertion, which bounds the number of merge maps in memory. Each file
+	// sends its chunks to its channel of results, which is closed after the
+	// last one.
+	sem := make(chan struct{}, concurrency)
+	results := make([]chan parsedFile, len(pending))
+	for i := range results {
+		results[i] = make(chan parsedFile, 1)
+	}
+
+	go func() {
+		for i, url := range pending {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(i int, fname string) {
+				defer close(results[i])
+
+				send := func(r parsedFile) error {
+					select {
+					case results[i] <- r:
+						return nil
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+
+				opts.metrics.fileStarted("build")
+				totals, err := parseFile(ctx, n, fname, opts, func(chunk map[string]int64) error {
+					return send(parsedFile{totals: chunk})
+				})
+				opts.metrics.fileStopped("build")
+				send(parsedFile{totals: totals, err: err})
+			}(i, findDataFile(dir, url))
+		}
+	}()
+
+	for i, url := range pending {
+		err := insertParsed(ctx, store, url, findDataFile(dir, url), results[i], opts)
+		<-sem
+		if err != nil {
+			return err
+		}
+
+		if err := m.markBuilt(url); err != nil {
+			return err
+		}
+		opts.metrics.fileCompleted("build")
+	}
+
+	return nil
+}
+
+// pendingSources returns the urls which are neither recorded as built in m
+// nor completely loaded into store. The urls loaded into store are recorded
+// in m.
+func pendingSources(ctx context.Context, store Store, urls []string, m *manifest) ([]string, error) {
+	tracker, _ := store.(sourceTracker)
+
+	var pending []string
+	for _, url := range urls {
+		if m.isBuilt(url) {
+			continue
+		}
+
+		if tracker != nil {
+			completed, err := tracker.sourceCompleted(ctx, url)
+			if err != nil {
+				return nil, err
+			}
+			if completed {
+				if err := m.markBuilt(url); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		pending = append(pending, url)
+	}
+
+	return pending, nil
+}
+
+// insertParsed inserts the chunks of the aggregated n-grams of the file of url
+// received from parsed until it is closed.
+func insertParsed(ctx context.Context, store Store, url, name string, parsed <-chan parsedFile, opts buildOptions) error {
+	ins, err := startInsert(ctx, store, url, name, opts)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case r, ok := <-parsed:
+			if !ok {
+				return ins.finish(ctx)
+			}
+			if r.err != nil {
+				return r.err
+			}
+			if err := ins.insert(ctx, r.totals); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("cannot build %s: %w", url, ctx.Err())
+		}
+	}
+}
+
+// parsedFile is the aggregated n-grams of a file, or a chunk of them, or the
+// error of parsing it.
+type parsedFile struct {
+	totals map[string]int64
+	err    error
+}
+
+// parseFile aggregates every n-gram of the ngram file fname, which is
+// decompressed by the format its extension tells. flush is called as by
+// aggregateNgrams.
+func parseFile(ctx context.Context, n int, fname string, opts buildOptions, flush func(map[string]int64) error) (map[string]int64, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("cannot build %s: %w", fname, err)
+	}
+	defer f.Close()
+
+	// A file saved with Decompress is read as is.
+	d, ok := decompressorOf(fname)
+	if !ok {
+		return aggregateNgrams(ctx, n, fname, bufio.NewReader(f), opts, flush)
+	}
+
+	r, err := d.newReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("cannot build %s: %w", fname, err)
+	}
+	defer r.Close()
+
+	return aggregateNgrams(ctx, n, fname, r, opts, flush)
+}
+
+// streamBuild inserts the ngram files of urls into store while downloading them,
+// without saving them to disk. A failed download is retried from the
+// beginning since nothing is inserted until a file is read completely, as
+// configured by the client, retries and timeout of dlOpts. Files recorded as
+// built in m are skipped.
+func streamBuild(ctx context.Context, store Store, ngram string, urls []string, opts buildOptions, dlOpts downloadOptions, m *manifest) error {
+	n, err := strconv.Atoi(ngram)
+	if err != nil {
+		return fmt.Errorf("cannot build: invalid ngram: %q", ngram)
+	}
+
+	pending, err := pendingSources(ctx, store, urls, m)
+	if err != nil {
+		return err
+	}
+
+	for _, url := range pending {
+		opts.metrics.fileStarted("build")
+		err = dlOpts.retry.retry(ctx, func() error {
+			return permanentUnlessRetryable(streamBuildURL(ctx, dlOpts.client, store, n, url, opts, dlOpts.timeout))
+		}, dlOpts.logRetry(url))
+		opts.metrics.fileStopped("build")
+		if err != nil {
+			if ctx.Err() == nil {
+				opts.metrics.fileFailed()
+			}
+			return fmt.Errorf("cannot build %s: %w", url, err)
+		}
+
+		if err := m.markBuilt(url); err != nil {
+			return err
+		}
+		opts.metrics.fileCompleted("build")
+	}
+
+	return nil
+}
+
+func streamBuildURL(ctx context.Context, client HTTPClient, store Store, n int, url string, opts buildOptions, timeout time.Duration) error {
+	reqCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	r, err := openDataURL(reqCtx, client, url, opts.metrics)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := buildReader(ctx, store, n, url, url, r, opts); err != nil {
+		if errors.Is(err, errNgramRead) {
+			return &retryableError{err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// openDataURL gets the data file of url and returns its decompressed content,
+// counting the downloaded bytes in metrics. The failures which may succeed if
+// tried again are retryableErrors.
+func openDataURL(ctx context.Context, client HTTPClient, url string, metrics *comboMetrics) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &retryableError{err: err}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, statusError(resp, fmt.Errorf("cannot get %s: %s", url, resp.Status))
+	}
+
+	r, err := urlDecompressor(url).newReader(bufio.NewReader(metrics.reader(resp.Body)))
+	if err != nil {
+		resp.Body.Close()
+		return nil, &retryableError{err: err}
+	}
+	return &dataURLReader{ReadCloser: r, body: resp.Body}, nil
+}
+
+// dataURLReader is the decompressed content of a response body, closing both.
+type dataURLReader struct {
+	io.ReadCloser
+	body io.Closer
+}
+
+func (r *dataURLReader) Close() error {
+	err := r.ReadCloser.Close()
+	if berr := r.body.Close(); err == nil {
+		err = berr
+	}
+	return err
+}
+
+// buildReader inserts every n-gram read from the decompressed ngram file r of
+// url into store. name is used in error messages.
+func buildReader(ctx context.Context, store Store, n int, url, name string, r io.Reader, opts buildOptions) error {
+	totals, err := aggregateNgrams(ctx, n, name, r, opts, nil)
+	if err != nil {
+		return err
+	}
+	return insertTotals(ctx, store, url, name, totals, opts)
+}
+
+// aggregateNgrams reads every n-gram of the decompressed ngram file r into a
+// map from the tokens joined with " " to their total match count.
+//
+// Only the total match count of each n-gram is kept: the per-year counts in
+// the year range are summed, and a token sequence which is split across
+// several lines of the file is accumulated into a single entry. A line whose
+// number of tokens is not n is skipped with a warning.
+//
+// If opts.maxMergeEntries is positive and flush is not nil, the map is passed
+// to flush and replaced with an empty one whenever it reaches that many
+// entries, and the remaining entries are returned.
+func aggregateNgrams(ctx context.Context, n int, name string, r io.Reader, opts buildOptions, flush func(map[string]int64) error) (map[string]int64, error) {
+	totals := make(map[string]int64)
+	var skipped int
+
+	sc := NewNgramScanner(opts.progress.reader(r))
+	sc.SkipHeader = opts.skipHeader
+	for lines := 1; sc.Scan(); lines++ {
+		if lines%4096 == 0 && ctx.Err() != nil {
+			return nil, fmt.Errorf("cannot build %s: %w", name, ctx.Err())
+		}
+
+		ngram := sc.Ngram()
+		if len(ngram.Tokens) != n {
+			// Such as a line with a stray tab within its tokens.
+			slog.Warn("skip ngram line", "file", name, "line", sc.line, "expected", n, "tokens", ngram.Tokens)
+			skipped++
+			continue
+		}
+
+		tokens := ngram.Tokens
+		if !opts.keepBoundaries {
+			// Dropping a boundary token leaves fewer than n tokens, or none
+			// of a 1-gram.
+			if tokens = stripBoundaries(tokens); len(tokens) != n {
+				continue
+			}
+		}
+		if opts.stripPOS {
+			// Dropping a bare tag token leaves fewer than n tokens.
+			if tokens = stripPOS(tokens); len(tokens) != n {
+				continue
+			}
+		}
+
+		if opts.lowercase {
+			tokens = lowercaseTokens(tokens)
+		}
+
+		totals[strings.Join(tokens, " ")] += sumMatchCount(ngram.Counts, opts.yearStart, opts.yearEnd)
+
+		if flush != nil && opts.maxMergeEntries > 0 && len(totals) >= opts.maxMergeEntries {
+			if err := flush(totals); err != nil {
+				return nil, fmt.Errorf("cannot build %s: %w", name, err)
+			}
+			totals = make(map[string]int64)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("cannot build %s: %w", name, err)
+	}
+	if skipped > 0 {
+		slog.Warn("skipped ngram lines", "file", name, "lines", skipped)
+	}
+
+	return totals, nil
+}
+
+// insertTotals inserts the aggregated n-grams of totals of the file of url
+// into store and flushes it.
+func insertTotals(ctx context.Context, store Store, url, name string, totals map[string]int64, opts buildOptions) error {
+	ins, err := startInsert(ctx, store, url, name, opts)
+	if err != nil {
+		return err
+	}
+	if err := ins.insert(ctx, totals); err != nil {
+		return err
+	}
+	return ins.finish(ctx)
+}
+
+// totalsInserter inserts the aggregated n-grams of a file into a store in one
+// or more chunks. The min-count filter applies to each chunk.
+//
+// If store is a sourceTracker, the n-grams of each chunk are inserted in the
+// order of their tokens, and the rows committed by a previous build are
+// skipped.
+type totalsInserter struct {
+	store   Store
+	url     string
+	name    string
+	opts    buildOptions
+	tracker sourceTracker
+
+	// skip is the number of rows committed by a previous build which are
+	// still to be skipped, and rows the number of rows found so far.
+	skip int64
+	rows int64
+}
+
+func startInsert(ctx context.Context, store Store, url, name string, opts buildOptions) (*totalsInserter, error) {
+	ins := &totalsInserter{store: store, url: url, name: name, opts: opts}
+
+	if tracker, ok := store.(sourceTracker); ok {
+		done, err := tracker.startSource(ctx, url)
+		if err != nil {
+			return nil, fmt.Errorf("cannot build %s: %w", name, err)
+		}
+		ins.tracker, ins.skip = tracker, done
+	}
+	return ins, nil
+}
+
+func (ins *totalsInserter) insert(ctx context.Context, totals map[string]int64) error {
+	keys := make([]string, 0, len(totals))
+	for key, count := range totals {
+		if count == 0 || count < ins.opts.minCount {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	ins.rows += int64(len(keys))
+
+	if ins.tracker != nil || ins.opts.deterministic {
+		sort.Strings(keys)
+	}
+	skip := min(ins.skip, int64(len(keys)))
+	keys, ins.skip = keys[skip:], ins.skip-skip
+
+	for _, key := range keys {
+		if err := ins.store.Insert(ctx, strings.Split(key, " "), totals[key]); err != nil {
+			return fmt.Errorf("cannot build %s: %w", ins.name, err)
+		}
+		ins.opts.metrics.rowInserted()
+		ins.opts.progress.rowInserted()
+	}
+	return nil
+}
+
+// finish flushes the store and records the file as completely loaded.
+func (ins *totalsInserter) finish(ctx context.Context) error {
+	if ins.skip > 0 {
+		return fmt.Errorf("cannot build %s: %d rows already loaded but %d found", ins.name, ins.rows+ins.skip, ins.rows)
+	}
+
+	if err := ins.store.Flush(ctx); err != nil {
+		return fmt.Errorf("cannot build %s: %w", ins.name, err)
+	}
+
+	if ins.tracker != nil {
+		if err := ins.tracker.completeSource(ctx, ins.url); err != nil {
+			return fmt.Errorf("cannot build %s: %w", ins.name, err)
+		}
+	}
+	return nil
+}
+
+func lowercaseTokens(tokens []string) []string {
+	lowered := make([]string, len(tokens))
+	for i, token := range tokens {
+		lowered[i] = strings.ToLower(token)
+	}
+	return lowered
+}
+
+func sumMatchCount(counts []NgramCount, yearStart, yearEnd int) int64 {
+	var sum int64
+	for _, c := range counts {
+		if c.Year < yearStart || yearEnd < c.Year {
+			continue
+		}
+		sum += c.MatchCount
+	}
+	return sum
+}
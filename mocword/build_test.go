@@ -0,0 +1,305 @@
+package mocword
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// readJSONL returns the counts of the n-grams of the jsonl export fname,
+// failing if an n-gram is in several lines.
+func readJSONL(t *testing.T, fname string) map[string]int64 {
+	t.Helper()
+
+	f, err := os.Open(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	counts := make(map[string]int64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec jsonlRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("%s: %v", fname, err)
+		}
+		key := strings.Join(rec.Tokens, " ")
+		if _, ok := counts[key]; ok {
+			t.Errorf("%s: %q is in several lines", fname, key)
+		}
+		counts[key] = rec.Count
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+// testBuildOptions returns the options building the files of writeTestData in
+// a new source dir.
+func testBuildOptions(t *testing.T) BuildOptions {
+	t.Helper()
+
+	src := t.TempDir()
+	writeTestData(t, src)
+	opts := BuildOptions{Dir: t.TempDir(), SourceDir: src}
+	opts.Languages = []string{"eng"}
+	opts.Ngrams = []string{"1"}
+	return opts
+}
+
+func TestBuildRerunExport(t *testing.T) {
+	ctx := context.Background()
+
+	for _, withDB := range []bool{false, true} {
+		opts := testBuildOptions(t)
+		opts.Format = "jsonl"
+		opts.Export = filepath.Join(opts.Dir, "ngrams.jsonl")
+		if withDB {
+			opts.DB = filepath.Join(opts.Dir, "ngrams.db")
+		}
+
+		for run := 1; run <= 2; run++ {
+			if err := Build(ctx, opts); err != nil {
+				t.Fatalf("db %v, run %d: %v", withDB, run, err)
+			}
+			assertCounts(t, opts.Export, readJSONL(t, opts.Export), testDataCounts)
+			if withDB {
+				assertCounts(t, opts.DB, readCounts(t, opts.DB), testDataCounts)
+			}
+		}
+	}
+}
+
+// memStore is a Store keeping the inserted n-grams in memory.
+type memStore struct {
+	counts map[string]int64
+	rows   int
+}
+
+func newMemStore() *memStore {
+	return &memStore{counts: make(map[string]int64)}
+}
+
+func (s *memStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	s.counts[strings.Join(tokens, " ")] += count
+	s.rows++
+	return nil
+}
+
+func (s *memStore) Flush(ctx context.Context) error { return nil }
+
+func (s *memStore) Close() error { return nil }
+
+func TestBuildTablePrefixes(t *testing.T) {
+	ctx := context.Background()
+	opts := testBuildOptions(t)
+	opts.DB = filepath.Join(t.TempDir(), "ngrams.db")
+
+	for _, prefix := range []string{"", "a", "b"} {
+		opts.TablePrefix = prefix
+		if err := Build(ctx, opts); err != nil {
+			t.Fatalf("Build() with table prefix %q: %v", prefix, err)
+		}
+	}
+
+	for _, table := range []string{"one_grams", "a_one_grams", "b_one_grams"} {
+		assertCounts(t, table, readTableCounts(t, opts.DB, table), testDataCounts)
+	}
+}
+
+func TestBuildOptionsFSTCompress(t *testing.T) {
+	tests := []struct {
+		export, compress string
+		valid            bool
+	}{
+		{export: "ngrams.fst", valid: true},
+		{export: "ngrams.fst", compress: "none", valid: true},
+		{export: "ngrams.fst", compress: "gzip"},
+		{export: "ngrams.fst.gz"},
+		{export: "ngrams.fst.zst"},
+	}
+
+	for _, tt := range tests {
+		opts := testBuildOptions(t)
+		opts.Format, opts.Export, opts.Compress = "fst", filepath.Join(t.TempDir(), tt.export), tt.compress
+		if err := opts.validate(); (err == nil) != tt.valid {
+			t.Errorf("validate() of %s compressed %q error = %v, want valid %v", tt.export, tt.compress, err, tt.valid)
+		}
+	}
+}
+
+func TestInsertTotalsMinCount(t *testing.T) {
+	store := newMemStore()
+	opts := BuildOptions{MinCount: 5}.buildOptions()
+	totals := map[string]int64{"apple": 7, "banana": 5, "cherry": 4}
+
+	if err := insertTotals(context.Background(), store, "url", "name", totals, opts); err != nil {
+		t.Fatal(err)
+	}
+	assertCounts(t, "store", store.counts, map[string]int64{"apple": 7, "banana": 5})
+}
+
+// aggregateLines aggregates the n-gram lines with opts.
+func aggregateLines(t *testing.T, n int, lines []string, opts buildOptions) map[string]int64 {
+	t.Helper()
+
+	totals, err := aggregateNgrams(context.Background(), n, "test", strings.NewReader(strings.Join(lines, "\n")+"\n"), opts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return totals
+}
+
+func TestAggregateNgramsSplitLines(t *testing.T) {
+	lines := []string{
+		"apple pie\t2000,3,1\t2001,4,2",
+		"banana split\t2000,5,1",
+		"apple pie\t2002,10,3",
+	}
+	got := aggregateLines(t, 2, lines, BuildOptions{}.buildOptions())
+	assertCounts(t, "totals", got, map[string]int64{"apple pie": 17, "banana split": 5})
+}
+
+func TestAggregateNgramsStripPOS(t *testing.T) {
+	lines := []string{"book_NOUN\t2000,3,1", "book\t2000,4,1", "_NOUN_\t2000,5,1"}
+
+	got := aggregateLines(t, 1, lines, BuildOptions{StripPOS: true}.buildOptions())
+	assertCounts(t, "stripped", got, map[string]int64{"book": 7})
+
+	got = aggregateLines(t, 1, lines, BuildOptions{}.buildOptions())
+	assertCounts(t, "kept", got, map[string]int64{"book_NOUN": 3, "book": 4, "_NOUN_": 5})
+}
+
+func TestAggregateNgramsLowercase(t *testing.T) {
+	lines := []string{"The cat\t2000,3,1", "the cat\t2000,4,1", "THE Cat\t2001,5,1"}
+
+	got := aggregateLines(t, 2, lines, BuildOptions{Lowercase: true}.buildOptions())
+	assertCounts(t, "lowercased", got, map[string]int64{"the cat": 12})
+
+	got = aggregateLines(t, 2, lines, BuildOptions{}.buildOptions())
+	assertCounts(t, "kept", got, map[string]int64{"The cat": 3, "the cat": 4, "THE Cat": 5})
+}
+
+func TestAggregateNgramsKeepBoundaries(t *testing.T) {
+	lines := []string{"_START_ the\t2000,3,1", "the cat\t2000,4,1", "cat _END_\t2001,5,1"}
+
+	got := aggregateLines(t, 2, lines, BuildOptions{}.buildOptions())
+	assertCounts(t, "stripped", got, map[string]int64{"the cat": 4})
+
+	got = aggregateLines(t, 2, lines, BuildOptions{KeepBoundaries: true}.buildOptions())
+	assertCounts(t, "kept", got, map[string]int64{"_START_ the": 3, "the cat": 4, "cat _END_": 5})
+}
+
+func TestAggregateNgramsSkipsTokenCount(t *testing.T) {
+	lines := []string{"the cat\t2000,3,1", "the\tdog\t2000,4,1", "a dog\t2000,5,1"}
+
+	got := aggregateLines(t, 2, lines, BuildOptions{}.buildOptions())
+	assertCounts(t, "totals", got, map[string]int64{"the cat": 3, "a dog": 5})
+}
+
+func TestParseFileZstd(t *testing.T) {
+	var b bytes.Buffer
+	w, err := zstd.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(strings.Join(testDataLines[0], "\n") + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fname := filepath.Join(t.TempDir(), "1-00000-of-00001.zst")
+	if err := os.WriteFile(fname, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseFile(context.Background(), 1, fname, BuildOptions{}.buildOptions(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertCounts(t, fname, got, map[string]int64{"apple": 7, "banana": 5, "cherry": 7})
+}
+
+func TestBuildMaxMergeEntries(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	lines := []string{
+		"apple\t2000,1,1", "banana\t2000,2,1", "apple\t2001,3,1",
+		"cherry\t2000,4,1", "banana\t2001,5,1", "apple\t2002,6,1",
+	}
+	writeGzip(t, filepath.Join(dir, "1-00000-of-00001.gz"), strings.Join(lines, "\n")+"\n")
+	urls := []string{"https://example.com/1-00000-of-00001.gz"}
+	want := map[string]int64{"apple": 10, "banana": 7, "cherry": 4}
+
+	opts := BuildOptions{MaxMergeEntries: 2}.buildOptions()
+
+	var flushes int
+	_, err := parseFile(ctx, 1, filepath.Join(dir, "1-00000-of-00001.gz"), opts, func(map[string]int64) error {
+		flushes++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flushes < 2 {
+		t.Errorf("flushed %d times, want at least 2", flushes)
+	}
+
+	db := filepath.Join(dir, "ngrams.db")
+	s, err := newSQLiteStore(db, sqliteOptions{batchSize: 1, upsert: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := build(ctx, s, "1", dir, urls, opts, m); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	assertCounts(t, db, readCounts(t, db), want)
+}
+
+func BenchmarkBuildParseConcurrency(b *testing.B) {
+	dir := b.TempDir()
+	var urls []string
+	for i := 0; i < 8; i++ {
+		var lines strings.Builder
+		for j := 0; j < 20000; j++ {
+			lines.WriteString("word" + strconv.Itoa(j) + "\t2000,3,1\t2001,4,2\n")
+		}
+		name := "1-0000" + strconv.Itoa(i) + "-of-00008.gz"
+		writeGzip(b, filepath.Join(dir, name), lines.String())
+		urls = append(urls, "https://example.com/"+name)
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		b.Run(strconv.Itoa(concurrency), func(b *testing.B) {
+			opts := BuildOptions{ParseConcurrency: concurrency}.buildOptions()
+			for i := 0; i < b.N; i++ {
+				m, err := loadManifest(dir, "")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := build(context.Background(), newMemStore(), "1", dir, urls, opts, m); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
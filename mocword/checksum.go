@@ -0,0 +1,104 @@
+package mocword
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const checksumsFileName = "checksums.txt"
+
+// loadChecksums reads "<sha256 hex>  <url>" lines, the format written by
+// saveChecksums.
+func loadChecksums(fname string) (map[string]string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load checksums: %w", err)
+	}
+	defer f.Close()
+
+	checksums := make(map[string]string)
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("cannot load checksums: %s:%d: invalid line: %q", fname, line, text)
+		}
+		checksums[fields[1]] = strings.ToLower(fields[0])
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("cannot load checksums: %w", err)
+	}
+
+	return checksums, nil
+}
+
+// lookupChecksum finds the checksum of url, which may be keyed by the url or
+// its file name.
+func lookupChecksum(checksums map[string]string, url string) (string, bool) {
+	if sum, ok := checksums[url]; ok {
+		return sum, true
+	}
+	sum, ok := checksums[path.Base(url)]
+	return sum, ok
+}
+
+// saveChecksums writes checksums sorted by url atomically.
+func saveChecksums(fname string, checksums map[string]string) error {
+	urls := make([]string, 0, len(checksums))
+	for url := range checksums {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname))
+	if err != nil {
+		return fmt.Errorf("cannot save checksums: %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	w := bufio.NewWriter(tmpfile)
+	for _, url := range urls {
+		fmt.Fprintf(w, "%s  %s\n", checksums[url], url)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot save checksums: %w", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		return fmt.Errorf("cannot save checksums: %w", err)
+	}
+
+	if err := os.Rename(tmpfile.Name(), fname); err != nil {
+		return fmt.Errorf("cannot save checksums: %w", err)
+	}
+	return nil
+}
+
+func fileSHA256(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", fmt.Errorf("cannot compute checksum: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("cannot compute checksum of %s: %w", fname, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
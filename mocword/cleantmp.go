@@ -0,0 +1,86 @@
+package mocword
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CleanTempFiles removes the temporary files left under dir by a run which was
+// killed before it could remove them, such as the ones of the manifest, the
+// checksums, the total counts and the copies of the moved downloads. Only
+// files last modified more than olderThan ago are removed, so the ones of a
+// running process are kept. The partial downloads are not removed since they
+// are resumed. A missing dir has nothing to clean.
+func CleanTempFiles(dir string, olderThan time.Duration) error {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	err := filepath.WalkDir(dir, func(fname string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || !isTempFileName(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		age := time.Since(info.ModTime())
+		if age < olderThan {
+			return nil
+		}
+
+		if err := os.Remove(fname); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		slog.Info("removed stale temp file", "file", fname, "bytes", info.Size(), "age", age.Round(time.Second))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot clean temp files: %w", err)
+	}
+	return nil
+}
+
+// decompressedTempFileName matches the temporary files of the data files
+// saved with Decompress.
+var decompressedTempFileName = regexp.MustCompile(`^[1-5]-[0-9]{5}-of-[0-9]{5}[0-9]+$`)
+
+// isTempFileName reports whether name is of a file created by
+// ioutil.TempFile in this package, which appends random digits to the name
+// of the file it replaces.
+func isTempFileName(name string) bool {
+	// The digits of a decompressed data file cannot be told from the random
+	// ones, so its name is matched as a whole.
+	if decompressedTempFileName.MatchString(name) {
+		return true
+	}
+
+	base := strings.TrimRight(name, "0123456789")
+	if base == name {
+		return false
+	}
+
+	switch {
+	case base == manifestFileName, base == checksumsFileName:
+		return true
+	case strings.HasSuffix(base, ".tsv") && strings.Contains(base, "-totalcounts-"):
+		return true
+	case strings.HasSuffix(base, ".gz"), strings.HasSuffix(base, ".zst"):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,344 @@
+package mocword
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const bucketListURL = "https://storage.googleapis.com/books/?prefix=ngrams/books/&delimiter=/"
+
+// resolveDatasetVersion replaces "latest" with the newest published dataset
+// version. It falls back to DefaultDatasetVersion if the discovery fails.
+func resolveDatasetVersion(ctx context.Context, client HTTPClient, version string) string {
+	if version != "latest" {
+		return version
+	}
+
+	latest, err := latestDatasetVersion(ctx, client)
+	if err != nil {
+		slog.Warn("falling back to the default dataset version", "version", DefaultDatasetVersion, "error", err)
+		return DefaultDatasetVersion
+	}
+	return latest
+}
+
+// latestDatasetVersion finds the newest release directory in the bucket listing.
+func latestDatasetVersion(ctx context.Context, client HTTPClient) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", bucketListURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot discover latest dataset version: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot discover latest dataset version: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot discover latest dataset version: %s: %s", bucketListURL, resp.Status)
+	}
+
+	var listing struct {
+		CommonPrefixes []struct {
+			Prefix string
+		}
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		return "", fmt.Errorf("cannot discover latest dataset version: %w", err)
+	}
+
+	latest := ""
+	for _, p := range listing.CommonPrefixes {
+		version := path.Base(p.Prefix)
+		if !isDatasetVersion(version) {
+			continue
+		}
+		if version > latest {
+			latest = version
+		}
+	}
+
+	if latest == "" {
+		return "", errors.New("cannot discover latest dataset version: no release found")
+	}
+	return latest, nil
+}
+
+// dataset locates a release of the Google Books Ngram dataset on the storage
+// at baseURL, which is Google's or a mirror.
+type dataset struct {
+	client  HTTPClient
+	baseURL string
+	version string
+
+	// maxIndexSize is the max size in bytes of an index page.
+	maxIndexSize int64
+
+	// retry tells how the index pages failing on transient errors are
+	// fetched again.
+	retry RetryPolicy
+}
+
+// totalCountsURL and downloadIndexURL return the urls of lang, an element of
+// Languages, which the storage uses verbatim in its paths, such as
+// "20200217/eng-us/eng-us-1-ngrams_exports.html".
+func (d dataset) totalCountsURL(lang, ngram string) string {
+	return fmt.Sprintf("%s/%s/%s/totalcounts-%s", strings.TrimSuffix(d.baseURL, "/"), d.version, lang, ngram)
+}
+
+func (d dataset) downloadIndexURL(lang, ngram string) string {
+	return fmt.Sprintf("%s/%s/%s/%s-%s-ngrams_exports.html", strings.TrimSuffix(d.baseURL, "/"), d.version, lang, lang, ngram)
+}
+
+// getHTML fetches and parses the HTML page of url, streaming the body into the
+// parser. It fails if the page is larger than maxSize bytes. The failures
+// which may succeed if tried again are retryableErrors.
+func getHTML(ctx context.Context, client HTTPClient, url string, maxSize int64) (doc *goquery.Document, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		err = fmt.Errorf("cannot get html: %w", err)
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		err = &retryableError{err: fmt.Errorf("cannot get html: %w", err)}
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = statusError(res, fmt.Errorf("cannot get html %s: status %d", url, res.StatusCode))
+		return
+	}
+
+	// One more byte than maxSize is read to tell a page of just maxSize bytes
+	// from a larger one.
+	body := &countingReader{r: io.LimitReader(res.Body, maxSize+1)}
+	doc, err = goquery.NewDocumentFromReader(body)
+	if err != nil {
+		err = &retryableError{err: fmt.Errorf("cannot read html %s: %w", url, err)}
+		return
+	}
+	if body.n > maxSize {
+		doc = nil
+		err = fmt.Errorf("cannot read html %s: larger than %d bytes", url, maxSize)
+		return
+	}
+
+	return
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// fetchDataURLList lists the data urls of the index page of lang and ngram,
+// which is fetched again on transient failures as told by ds.retry.
+func fetchDataURLList(ctx context.Context, ds dataset, lang, ngram string) ([]string, error) {
+	indexURL := ds.downloadIndexURL(lang, ngram)
+
+	var doc *goquery.Document
+	err := ds.retry.retry(ctx, func() error {
+		var err error
+		doc, err = getHTML(ctx, ds.client, indexURL, ds.maxIndexSize)
+		return permanentUnlessRetryable(err)
+	}, func(attempt int, wait time.Duration, err error) {
+		slog.Warn("retry index", "url", indexURL, "attempt", attempt, "max_attempts", ds.retry.MaxAttempts, "wait", wait, "error", err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dataURLList(indexURL, doc)
+}
+
+// dataURLList extracts the data urls from the index page. Relative hrefs are
+// resolved against indexURL. Duplicated links are listed once, and the urls
+// are sorted so that runs download in a stable order. Every malformed list
+// item is reported, since a partial list would silently skip files.
+func dataURLList(indexURL string, doc *goquery.Document) ([]string, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get data urls: %w", err)
+	}
+
+	var urls []string
+	var errs []error
+	seen := make(map[string]bool)
+
+	doc.Find("li").Each(func(i int, s *goquery.Selection) {
+		href, ok := s.Find("a").Attr("href")
+		if !ok {
+			errs = append(errs, fmt.Errorf("item %d: no href: %q", i+1, strings.TrimSpace(s.Text())))
+			return
+		}
+
+		ref, err := url.Parse(href)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("item %d: %w", i+1, err))
+			return
+		}
+
+		u := base.ResolveReference(ref).String()
+		if seen[u] {
+			return
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	})
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("cannot get data urls %s: %w", indexURL, errors.Join(errs...))
+	}
+
+	sort.Strings(urls)
+	return urls, nil
+}
+
+// combo is a selected pair of a language and an ngram with its data urls.
+type combo struct {
+	lang  string
+	ngram string
+	urls  []string
+
+	// dir is the directory of the data files of a source dir, which are
+	// built in place.
+	dir string
+}
+
+// ErrUnavailable is returned in strict mode if a selected language does not
+// publish a selected ngram.
+var ErrUnavailable = errors.New("unavailable language and ngram")
+
+// comboDir returns the subdirectory of dir holding the data files of c of the
+// dataset version, "<dir>/<version>/<lang>/<ngram>" as laid out on the
+// dataset storage.
+func comboDir(dir, version string, c combo) string {
+	return filepath.Join(dir, version, c.lang, c.ngram)
+}
+
+// listCombos lists the data urls of every pair of langs and ngrams. The pairs
+// whose index page does not exist are found with HEAD requests before any
+// listing, and are reported together. So are the pairs whose index page is
+// fetched but lists no data url. They are skipped unless strict, which makes
+// them an error. An index page which cannot be fetched is always an error.
+func listCombos(ctx context.Context, ds dataset, langs, ngrams []string, strict bool) ([]combo, error) {
+	var pairs []combo
+	var missing []string
+
+	for _, lang := range langs {
+		for _, ngram := range ngrams {
+			ok, err := indexExists(ctx, ds, lang, ngram)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				missing = append(missing, lang+"/"+ngram)
+				continue
+			}
+			pairs = append(pairs, combo{lang: lang, ngram: ngram})
+		}
+	}
+
+	if len(missing) > 0 {
+		if strict {
+			return nil, fmt.Errorf("cannot list data urls: %w: %s", ErrUnavailable, strings.Join(missing, ", "))
+		}
+		slog.Warn("skipping unavailable languages and ngrams", "combos", strings.Join(missing, ","))
+	}
+
+	combos := make([]combo, 0, len(pairs))
+	var empty []string
+	for _, c := range pairs {
+		list, err := fetchDataURLList(ctx, ds, c.lang, c.ngram)
+		if err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			empty = append(empty, c.lang+"/"+c.ngram)
+			continue
+		}
+		c.urls = list
+		combos = append(combos, c)
+	}
+
+	if len(empty) > 0 {
+		if strict {
+			return nil, fmt.Errorf("cannot list data urls: %w: empty index: %s", ErrUnavailable, strings.Join(empty, ", "))
+		}
+		slog.Warn("skipping languages and ngrams without data urls", "combos", strings.Join(empty, ","))
+	}
+
+	return combos, nil
+}
+
+// indexExists reports whether the download index page of lang and ngram
+// exists, which it does not only if the storage responds 404. The HEAD
+// request is retried with ds.retry like the index pages are fetched.
+func indexExists(ctx context.Context, ds dataset, lang, ngram string) (bool, error) {
+	url := ds.downloadIndexURL(lang, ngram)
+
+	var exists bool
+	err := ds.retry.retry(ctx, func() error {
+		var err error
+		exists, err = headIndex(ctx, ds.client, url)
+		return permanentUnlessRetryable(err)
+	}, func(attempt int, wait time.Duration, err error) {
+		slog.Warn("retry index", "url", url, "attempt", attempt, "max_attempts", ds.retry.MaxAttempts, "wait", wait, "error", err)
+	})
+	return exists, err
+}
+
+// headIndex sends a HEAD request of the index page url. The failures which
+// may succeed if tried again are retryableErrors.
+func headIndex(ctx context.Context, client HTTPClient, url string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return false, fmt.Errorf("cannot check %s: %w", url, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, &retryableError{err: fmt.Errorf("cannot check %s: %w", url, err)}
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, statusError(resp, fmt.Errorf("cannot check %s: %s", url, resp.Status))
+}
+
+// isDatasetVersion reports whether version is a release date as YYYYMMDD.
+func isDatasetVersion(version string) bool {
+	if len(version) != 8 {
+		return false
+	}
+	_, err := time.Parse("20060102", version)
+	return err == nil
+}
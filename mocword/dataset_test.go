@@ -0,0 +1,350 @@
+package mocword
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// testStorage is a dataset storage serving files by their paths, such as
+// "/20200217/eng/eng-1-ngrams_exports.html", and recording the requests. The
+// first unavailable[path] requests of a path fail with 503.
+type testStorage struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	files       map[string]string
+	unavailable map[string]int
+	requests    []string
+}
+
+func newTestStorage(t *testing.T, files map[string]string) *testStorage {
+	t.Helper()
+
+	s := &testStorage{files: files, unavailable: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		body, ok := s.files[r.URL.Path]
+		unavailable := s.unavailable[r.URL.Path] > 0
+		if unavailable {
+			s.unavailable[r.URL.Path]--
+		}
+		s.mu.Unlock()
+
+		if unavailable {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// requestsOf returns the paths requested with method.
+func (s *testStorage) requestsOf(method string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var paths []string
+	for _, req := range s.requests {
+		if p, ok := strings.CutPrefix(req, method+" "); ok {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// testIndexPage returns an index page listing hrefs.
+func testIndexPage(hrefs ...string) string {
+	var b strings.Builder
+	b.WriteString("<html><body><ul>\n")
+	for _, href := range hrefs {
+		fmt.Fprintf(&b, "<li><a href=%q>%s</a></li>\n", href, href)
+	}
+	b.WriteString("</ul></body></html>\n")
+	return b.String()
+}
+
+// testSource returns the Source of the 1-grams of eng of s.
+func testSource(s *testStorage) Source {
+	return Source{
+		Client:         s.Client(),
+		BaseURL:        s.URL,
+		DatasetVersion: DefaultDatasetVersion,
+		Languages:      []string{"eng"},
+		Ngrams:         []string{"1"},
+	}
+}
+
+func TestFetchDataURLListRetry(t *testing.T) {
+	path := "/" + DefaultDatasetVersion + "/eng/eng-1-ngrams_exports.html"
+	s := newTestStorage(t, map[string]string{path: testIndexPage("1-00000-of-00001.gz")})
+	s.unavailable[path] = 2
+
+	clock := &fakeClock{}
+	ds := testSource(s).dataset(context.Background())
+	ds.retry = RetryPolicy{MaxAttempts: 3, after: clock.after}
+
+	urls, err := fetchDataURLList(context.Background(), ds, "eng", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := s.URL + "/" + DefaultDatasetVersion + "/eng/1-00000-of-00001.gz"; len(urls) != 1 || urls[0] != want {
+		t.Errorf("urls = %v, want [%s]", urls, want)
+	}
+	if want := []time.Duration{time.Second, 2 * time.Second}; !equalDurations(clock.waits, want) {
+		t.Errorf("waits = %v, want %v", clock.waits, want)
+	}
+	if got := len(s.requestsOf("GET")); got != 3 {
+		t.Errorf("GET requests = %d, want 3", got)
+	}
+}
+
+func TestFetchDataURLListNotRetried(t *testing.T) {
+	s := newTestStorage(t, nil)
+
+	clock := &fakeClock{}
+	ds := testSource(s).dataset(context.Background())
+	ds.retry = RetryPolicy{MaxAttempts: 3, after: clock.after}
+
+	if _, err := fetchDataURLList(context.Background(), ds, "eng", "1"); err == nil {
+		t.Error("fetchDataURLList() of a missing page succeeded, want an error")
+	}
+	if len(clock.waits) != 0 {
+		t.Errorf("a 404 was retried after %v", clock.waits)
+	}
+}
+
+func TestIndexExistsRetry(t *testing.T) {
+	path := "/" + DefaultDatasetVersion + "/eng/eng-1-ngrams_exports.html"
+	s := newTestStorage(t, map[string]string{path: testIndexPage("1-00000-of-00001.gz")})
+	s.unavailable[path] = 2
+
+	clock := &fakeClock{}
+	ds := testSource(s).dataset(context.Background())
+	ds.retry = RetryPolicy{MaxAttempts: 3, after: clock.after}
+
+	exists, err := indexExists(context.Background(), ds, "eng", "1")
+	if err != nil || !exists {
+		t.Errorf("indexExists() = %v, %v, want true", exists, err)
+	}
+	if got := len(s.requestsOf("HEAD")); got != 3 {
+		t.Errorf("HEAD requests = %d, want 3", got)
+	}
+
+	exists, err = indexExists(context.Background(), ds, "fre", "1")
+	if err != nil || exists {
+		t.Errorf("indexExists() of a missing page = %v, %v, want false", exists, err)
+	}
+	if len(clock.waits) != 2 {
+		t.Errorf("waits = %v, want the 2 of the 503s only", clock.waits)
+	}
+}
+
+func TestDatasetURLs(t *testing.T) {
+	ds := dataset{baseURL: "https://mirror.example.com/ngrams/", version: "20120701"}
+
+	if got, want := ds.totalCountsURL("eng-us", "3"), "https://mirror.example.com/ngrams/20120701/eng-us/totalcounts-3"; got != want {
+		t.Errorf("totalCountsURL() = %q, want %q", got, want)
+	}
+	if got, want := ds.downloadIndexURL("eng-us", "3"), "https://mirror.example.com/ngrams/20120701/eng-us/eng-us-3-ngrams_exports.html"; got != want {
+		t.Errorf("downloadIndexURL() = %q, want %q", got, want)
+	}
+}
+
+func TestListURLsCombos(t *testing.T) {
+	files := make(map[string]string)
+	for _, lang := range []string{"eng", "fre"} {
+		for _, ngram := range []string{"1", "2"} {
+			files["/"+DefaultDatasetVersion+"/"+lang+"/"+lang+"-"+ngram+"-ngrams_exports.html"] = testIndexPage(ngram + "-00000-of-00001.gz")
+		}
+	}
+	s := newTestStorage(t, files)
+
+	src := testSource(s)
+	src.Languages = []string{"eng", "fre"}
+	src.Ngrams = []string{"1", "2"}
+	urls, err := ListURLs(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 4 {
+		t.Errorf("ListURLs() = %v, want 4 urls", urls)
+	}
+
+	fetched := s.requestsOf("GET")
+	if len(fetched) != 4 {
+		t.Errorf("fetched %v, want the 4 index pages", fetched)
+	}
+	for _, p := range fetched {
+		if _, ok := files[p]; !ok {
+			t.Errorf("fetched %s, which is not an index page", p)
+		}
+	}
+}
+
+// testDoc parses page as an index page.
+func testDoc(t *testing.T, page string) *goquery.Document {
+	t.Helper()
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestDataURLListResolve(t *testing.T) {
+	indexURL := "https://storage.example.com/books/20200217/eng/eng-1-ngrams_exports.html"
+	doc := testDoc(t, testIndexPage(
+		"1-00000-of-00004.gz",
+		"../eng/1-00001-of-00004.gz",
+		"https://other.example.com/eng/1-00002-of-00004.gz",
+		"//cdn.example.com/eng/1-00003-of-00004.gz",
+	))
+
+	got, err := dataURLList(indexURL, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://cdn.example.com/eng/1-00003-of-00004.gz",
+		"https://other.example.com/eng/1-00002-of-00004.gz",
+		"https://storage.example.com/books/20200217/eng/1-00000-of-00004.gz",
+		"https://storage.example.com/books/20200217/eng/1-00001-of-00004.gz",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("dataURLList() = %v, want %v", got, want)
+	}
+}
+
+func TestGetHTMLNotFound(t *testing.T) {
+	s := newTestStorage(t, nil)
+	url := s.URL + "/missing.html"
+
+	doc, err := getHTML(context.Background(), s.Client(), url, DefaultMaxIndexSize)
+	if err == nil || !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), url) {
+		t.Errorf("getHTML() error = %v, want one telling 404 and %s", err, url)
+	}
+	if doc != nil {
+		t.Errorf("getHTML() of a 404 returned a document")
+	}
+}
+
+func TestGetHTMLMaxSize(t *testing.T) {
+	page := testIndexPage("1-00000-of-00001.gz")
+	s := newTestStorage(t, map[string]string{"/index.html": page})
+	url := s.URL + "/index.html"
+
+	if _, err := getHTML(context.Background(), s.Client(), url, int64(len(page))); err != nil {
+		t.Errorf("getHTML() of a page of just the limit error = %v", err)
+	}
+
+	doc, err := getHTML(context.Background(), s.Client(), url, int64(len(page))-1)
+	if err == nil || !strings.Contains(err.Error(), "larger than") || !strings.Contains(err.Error(), url) {
+		t.Errorf("getHTML() error = %v, want one telling the page is too large", err)
+	}
+	if doc != nil {
+		t.Errorf("getHTML() of an oversized page returned a document")
+	}
+}
+
+func TestFetchDataURLListMaxIndexSize(t *testing.T) {
+	path := "/" + DefaultDatasetVersion + "/eng/eng-1-ngrams_exports.html"
+	s := newTestStorage(t, map[string]string{path: testIndexPage("1-00000-of-00001.gz")})
+
+	src := testSource(s)
+	src.MaxIndexSize = 16
+	ds := src.dataset(context.Background())
+
+	if _, err := fetchDataURLList(context.Background(), ds, "eng", "1"); err == nil || !strings.Contains(err.Error(), "larger than 16 bytes") {
+		t.Errorf("fetchDataURLList() error = %v, want one telling the index is larger than 16 bytes", err)
+	}
+}
+
+func TestDataURLListDuplicates(t *testing.T) {
+	indexURL := "https://storage.example.com/20200217/eng/eng-1-ngrams_exports.html"
+	doc := testDoc(t, testIndexPage(
+		"1-00001-of-00002.gz",
+		"1-00000-of-00002.gz",
+		"1-00001-of-00002.gz",
+		"https://storage.example.com/20200217/eng/1-00000-of-00002.gz",
+	))
+
+	got, err := dataURLList(indexURL, doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://storage.example.com/20200217/eng/1-00000-of-00002.gz",
+		"https://storage.example.com/20200217/eng/1-00001-of-00002.gz",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("dataURLList() = %v, want %v", got, want)
+	}
+}
+
+func TestDataURLListBrokenItem(t *testing.T) {
+	indexURL := "https://storage.example.com/20200217/eng/eng-1-ngrams_exports.html"
+	doc := testDoc(t, `<ul>
+<li><a href="1-00000-of-00003.gz">1-00000-of-00003.gz</a></li>
+<li>1-00001-of-00003.gz</li>
+<li><a href="1-00002-of-00003.gz">1-00002-of-00003.gz</a></li>
+</ul>`)
+
+	urls, err := dataURLList(indexURL, doc)
+	if err == nil || !strings.Contains(err.Error(), "item 2") {
+		t.Errorf("dataURLList() error = %v, want one telling item 2", err)
+	}
+	if urls != nil {
+		t.Errorf("dataURLList() = %v with a broken item, want no urls", urls)
+	}
+}
+
+func TestDatasetURLsLanguages(t *testing.T) {
+	const base = "https://storage.googleapis.com/books/ngrams/books/20200217/"
+	want := map[string][2]string{
+		"eng":         {base + "eng/totalcounts-2", base + "eng/eng-2-ngrams_exports.html"},
+		"eng-us":      {base + "eng-us/totalcounts-2", base + "eng-us/eng-us-2-ngrams_exports.html"},
+		"eng-gb":      {base + "eng-gb/totalcounts-2", base + "eng-gb/eng-gb-2-ngrams_exports.html"},
+		"eng-fiction": {base + "eng-fiction/totalcounts-2", base + "eng-fiction/eng-fiction-2-ngrams_exports.html"},
+		"chi_sim":     {base + "chi_sim/totalcounts-2", base + "chi_sim/chi_sim-2-ngrams_exports.html"},
+		"fre":         {base + "fre/totalcounts-2", base + "fre/fre-2-ngrams_exports.html"},
+		"ger":         {base + "ger/totalcounts-2", base + "ger/ger-2-ngrams_exports.html"},
+		"heb":         {base + "heb/totalcounts-2", base + "heb/heb-2-ngrams_exports.html"},
+		"ita":         {base + "ita/totalcounts-2", base + "ita/ita-2-ngrams_exports.html"},
+		"rus":         {base + "rus/totalcounts-2", base + "rus/rus-2-ngrams_exports.html"},
+		"spa":         {base + "spa/totalcounts-2", base + "spa/spa-2-ngrams_exports.html"},
+	}
+
+	ds := dataset{baseURL: DefaultBaseURL, version: DefaultDatasetVersion}
+	for _, lang := range Languages {
+		urls, ok := want[lang]
+		if !ok {
+			t.Errorf("no expected urls of %s", lang)
+			continue
+		}
+		if got := ds.totalCountsURL(lang, "2"); got != urls[0] {
+			t.Errorf("totalCountsURL(%q) = %q, want %q", lang, got, urls[0])
+		}
+		if got := ds.downloadIndexURL(lang, "2"); got != urls[1] {
+			t.Errorf("downloadIndexURL(%q) = %q, want %q", lang, got, urls[1])
+		}
+	}
+	if len(want) != len(Languages) {
+		t.Errorf("%d expected languages, want the %d of Languages", len(want), len(Languages))
+	}
+}
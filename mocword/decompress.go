@@ -0,0 +1,63 @@
+package mocword
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressor decompresses the data files compressed in one format, which
+// is told by the extension of their names.
+type decompressor interface {
+	// ext is the extension of the compressed files, such as ".gz".
+	ext() string
+
+	// newReader returns the decompressed content of r.
+	newReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// decompressors are the formats of the data files. Google publishes gzip, and
+// mirrors may re-compress them with zstd.
+var decompressors = []decompressor{gzipDecompressor{}, zstdDecompressor{}}
+
+// decompressorOf returns the decompressor of the file name by its extension,
+// and false if it is not compressed in a known format.
+func decompressorOf(name string) (decompressor, bool) {
+	for _, d := range decompressors {
+		if strings.HasSuffix(name, d.ext()) {
+			return d, true
+		}
+	}
+	return nil, false
+}
+
+// urlDecompressor returns the decompressor of the data file url, gzip if its
+// extension tells none.
+func urlDecompressor(url string) decompressor {
+	if d, ok := decompressorOf(url); ok {
+		return d
+	}
+	return gzipDecompressor{}
+}
+
+type gzipDecompressor struct{}
+
+func (gzipDecompressor) ext() string { return ".gz" }
+
+func (gzipDecompressor) newReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zstdDecompressor struct{}
+
+func (zstdDecompressor) ext() string { return ".zst" }
+
+func (zstdDecompressor) newReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
@@ -0,0 +1,128 @@
+package mocword
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// downloadEstimate is the amount of the downloads left in a run.
+type downloadEstimate struct {
+	files int
+	bytes int64
+}
+
+// estimateDownload sums the Content-Length of HEAD requests of the files of
+// the dataset version to download into the subdirectories of dir. Files
+// already downloaded are not counted and partially downloaded ones only count
+// their rest. A file whose size cannot be told, which its download is then
+// likely to fail too, is counted with an unknown size.
+func estimateDownload(ctx context.Context, opts downloadOptions, version string, combos []combo) (downloadEstimate, error) {
+	var est downloadEstimate
+
+	for _, c := range combos {
+		cOpts := opts
+		cOpts.dir = comboDir(opts.dir, version, c)
+		if opts.tmpDir != "" {
+			cOpts.tmpDir = comboDir(opts.tmpDir, version, c)
+		}
+
+		for _, url := range c.urls {
+			if fileExists(findDataFile(cOpts.dir, url)) && !opts.force {
+				continue
+			}
+
+			size, err := headContentLength(ctx, opts.client, url)
+			if err != nil {
+				if ctx.Err() != nil {
+					return downloadEstimate{}, fmt.Errorf("cannot estimate download size: %w", err)
+				}
+				slog.Warn("cannot estimate file size", "url", url, "error", err)
+			}
+			if fi, err := os.Stat(cOpts.partPath(url)); err == nil && !opts.force {
+				size -= fi.Size()
+			}
+
+			est.files++
+			if size > 0 {
+				est.bytes += size
+			}
+		}
+	}
+
+	return est, nil
+}
+
+// decompressRatio is the estimated ratio of the size of a decompressed data
+// file to the size of its download, which is at most about 5 for the gzipped
+// files of the dataset.
+const decompressRatio = 5
+
+// space returns the disk space taken by the downloads of e, which are saved
+// decompressed if decompress.
+func (e downloadEstimate) space(decompress bool) int64 {
+	if decompress {
+		return e.bytes * decompressRatio
+	}
+	return e.bytes
+}
+
+// ErrNotEnoughSpace is returned by Download if the files to download do not
+// fit in the free disk space.
+var ErrNotEnoughSpace = errors.New("not enough disk space")
+
+// checkDiskSpace aborts a run whose downloads of need bytes do not fit in the
+// free space of dir. The check is skipped if the free space is unknown.
+func checkDiskSpace(dir string, need int64) error {
+	free, ok, err := freeSpace(dir)
+	if err != nil {
+		return fmt.Errorf("cannot check disk space: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	if need > free {
+		return fmt.Errorf("%w in %s: need %s, available %s", ErrNotEnoughSpace, dir, formatBytes(need), formatBytes(free))
+	}
+	return nil
+}
+
+// headContentLength returns the Content-Length of url, or 0 if unknown.
+func headContentLength(ctx context.Context, client HTTPClient, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("cannot head %s: %w", url, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("cannot head %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, nil
+	}
+	return resp.ContentLength, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
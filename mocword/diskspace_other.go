@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package mocword
+
+// freeSpace is not supported on this platform, so the disk space check is
+// skipped.
+func freeSpace(dir string) (int64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,14 @@
+package mocword
+
+import "testing"
+
+func TestDownloadEstimateSpace(t *testing.T) {
+	est := downloadEstimate{files: 2, bytes: 1000}
+
+	if got := est.space(false); got != 1000 {
+		t.Errorf("space(false) = %d, want 1000", got)
+	}
+	if got := est.space(true); got != 1000*decompressRatio {
+		t.Errorf("space(true) = %d, want %d", got, 1000*decompressRatio)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package mocword
+
+import "syscall"
+
+// freeSpace returns the number of bytes available to the user in the
+// filesystem of dir.
+func freeSpace(dir string) (int64, bool, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true, nil
+}
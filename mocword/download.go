@@ -0,0 +1,436 @@
+package mocword
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func prepareOutputDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("cannot prepare output dir: not a directory: %s", dir)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return fmt.Errorf("cannot prepare output dir: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot prepare output dir: %w", err)
+	}
+	return nil
+}
+
+// downloadOptions configures do.
+type downloadOptions struct {
+	client  HTTPClient
+	dir     string
+	retry   RetryPolicy
+	timeout time.Duration
+
+	// tmpDir holds the partial files instead of dir if not empty.
+	tmpDir string
+
+	// verifyGzip fully decompresses the download to check its CRC before it is
+	// moved into place. Otherwise only the header of its compressed format is
+	// checked.
+	verifyGzip bool
+
+	// checksums maps urls or file names to their expected SHA-256. Files
+	// without an entry are not verified.
+	checksums map[string]string
+
+	// computed collects the SHA-256 of the downloaded compressed files by url
+	// if not nil.
+	computed map[string]string
+
+	// decompress saves the data files decompressed without their ".gz" or
+	// ".zst" suffix.
+	decompress bool
+
+	// force downloads the files again even if they exist, discarding their
+	// partial files.
+	force bool
+
+	// progress is updated with the downloaded bytes if not nil.
+	progress *progress
+
+	// metrics counts the downloaded bytes of the current combo if not nil.
+	metrics *comboMetrics
+}
+
+// comboOptions returns opts downloading the files of c of the dataset
+// version into their subdirectories of dir and tmpDir, which are created.
+func (opts downloadOptions) comboOptions(version string, c combo) (downloadOptions, error) {
+	opts.dir = comboDir(opts.dir, version, c)
+	if err := prepareOutputDir(opts.dir); err != nil {
+		return downloadOptions{}, err
+	}
+	if opts.tmpDir != "" {
+		opts.tmpDir = comboDir(opts.tmpDir, version, c)
+		if err := prepareOutputDir(opts.tmpDir); err != nil {
+			return downloadOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+// partPath returns the partial file of the download of url.
+func (opts downloadOptions) partPath(url string) string {
+	dir := opts.dir
+	if opts.tmpDir != "" {
+		dir = opts.tmpDir
+	}
+	return filepath.Join(dir, path.Base(url)) + ".part"
+}
+
+// dataFilePath returns the file of the data file url in dir, which has no
+// compression suffix if it is saved decompressed.
+func dataFilePath(dir, url string, decompress bool) string {
+	fname := filepath.Join(dir, path.Base(url))
+	if decompress {
+		return strings.TrimSuffix(fname, urlDecompressor(url).ext())
+	}
+	return fname
+}
+
+// findDataFile returns the file of the data file url in dir, whether it is
+// saved compressed or not. It is the compressed one if neither exists.
+func findDataFile(dir, url string) string {
+	fname := dataFilePath(dir, url, false)
+	if decompressed := dataFilePath(dir, url, true); !fileExists(fname) && fileExists(decompressed) {
+		return decompressed
+	}
+	return fname
+}
+
+func do(ctx context.Context, url string, opts downloadOptions) error {
+	absFname := dataFilePath(opts.dir, url, opts.decompress)
+	partFname := opts.partPath(url)
+
+	if existing := findDataFile(opts.dir, url); fileExists(existing) && !opts.force {
+		slog.Debug("skip downloaded file", "url", url, "file", existing)
+		return nil
+	}
+	if opts.force {
+		if err := os.Remove(partFname); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("do error: %w", err)
+		}
+	}
+
+	partfile, err := os.OpenFile(partFname, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("do error: %w", err)
+	}
+	// The partial file is kept on failure so that the next run can resume it,
+	// unless its content turns out to be broken. It is only renamed into place
+	// after a complete and verified download.
+	defer partfile.Close()
+
+	// sized tells whether the size of the download was checked against the
+	// one told by the server.
+	var sized bool
+	err = opts.retry.retry(ctx, func() error {
+		var err error
+		sized, err = fetchWithTimeout(ctx, opts.client, url, partfile, opts.timeout, opts.progress, opts.metrics)
+		if errors.Is(err, errSizeMismatch) {
+			os.Remove(partFname)
+		}
+		return permanentUnlessRetryable(err)
+	}, opts.logRetry(url))
+	if err != nil {
+		return fmt.Errorf("do error: %w", err)
+	}
+
+	if err := partfile.Close(); err != nil {
+		return fmt.Errorf("do error: %w", err)
+	}
+
+	// A download of an unknown size, such as a chunked response, may be
+	// truncated without notice, which only the whole compressed stream tells.
+	verify := verifyHeader
+	if opts.verifyGzip || !sized {
+		verify = verifyStream
+	}
+	if err := verify(partFname, urlDecompressor(url)); err != nil {
+		os.Remove(partFname)
+		return fmt.Errorf("do error: %w", err)
+	}
+
+	expected, ok := lookupChecksum(opts.checksums, url)
+	if ok || opts.computed != nil {
+		sum, err := fileSHA256(partFname)
+		if err != nil {
+			return fmt.Errorf("do error: %w", err)
+		}
+		if ok && sum != expected {
+			os.Remove(partFname)
+			return fmt.Errorf("do error: checksum mismatch %s: expected %s, got %s", url, expected, sum)
+		}
+		if opts.computed != nil {
+			opts.computed[url] = sum
+		}
+	}
+
+	if opts.decompress {
+		if err := decompressFile(partFname, absFname, urlDecompressor(url)); err != nil {
+			return fmt.Errorf("do error: %w", err)
+		}
+	} else if err := moveFile(partFname, absFname); err != nil {
+		return fmt.Errorf("do error: %w", err)
+	}
+
+	// A forced download replaces the file saved the other way too.
+	if other := dataFilePath(opts.dir, url, !opts.decompress); opts.force && other != absFname {
+		if err := os.Remove(other); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("do error: %w", err)
+		}
+	}
+
+	if fi, err := os.Stat(absFname); err == nil {
+		slog.Info("downloaded", "url", url, "file", absFname, "bytes", fi.Size())
+	}
+	return nil
+}
+
+var errSizeMismatch = errors.New("size mismatch")
+
+// retryableError marks a failure which may succeed if tried again later, or
+// after the delay told by the server if it is positive.
+type retryableError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+
+func (e *retryableError) Unwrap() error { return e.err }
+
+func (e *retryableError) retryAfter() time.Duration { return e.after }
+
+// permanentUnlessRetryable marks err as Permanent unless it is a
+// retryableError.
+func permanentUnlessRetryable(err error) error {
+	var rerr *retryableError
+	if err == nil || errors.As(err, &rerr) {
+		return err
+	}
+	return Permanent(err)
+}
+
+func isRetryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// statusError returns err of the failed response resp, which is a
+// retryableError if its status is retryable. A 429 or 503 is retried after
+// the delay told by its Retry-After header if any.
+func statusError(resp *http.Response, err error) error {
+	if !isRetryableStatus(resp.StatusCode) {
+		return err
+	}
+
+	re := &retryableError{err: err}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		re.after = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return re
+}
+
+// parseRetryAfter parses a Retry-After header of seconds or an HTTP date
+// into the delay from now. It is 0 if the header is missing or invalid, or
+// the date has passed.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// logRetry returns a callback of RetryPolicy.retry logging the retries of
+// the download of url.
+func (opts downloadOptions) logRetry(url string) func(int, time.Duration, error) {
+	return func(attempt int, wait time.Duration, err error) {
+		slog.Warn("retry download", "url", url, "attempt", attempt, "max_attempts", opts.retry.MaxAttempts, "wait", wait, "error", err)
+	}
+}
+
+func fetchWithTimeout(ctx context.Context, client HTTPClient, url string, partfile *os.File, timeout time.Duration, p *progress, m *comboMetrics) (bool, error) {
+	if timeout <= 0 {
+		return fetch(ctx, client, url, partfile, p, m)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return fetch(ctx, client, url, partfile, p, m)
+}
+
+// fetch appends the rest of url to partfile, resuming from its current size.
+// It reports whether the size of the whole file was known and matched.
+func fetch(ctx context.Context, client HTTPClient, url string, partfile *os.File, p *progress, m *comboMetrics) (bool, error) {
+	offset, err := partfile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, &retryableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// The server ignored the range, so start over from the beginning.
+		if err := partfile.Truncate(0); err != nil {
+			return false, err
+		}
+		if offset, err = partfile.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may already hold the whole content.
+		if total := contentRangeTotal(resp.Header.Get("Content-Range")); total < 0 || total != offset {
+			return false, fmt.Errorf("cannot resume %s from %d bytes", url, offset)
+		}
+		return true, nil
+	default:
+		return false, statusError(resp, fmt.Errorf("cannot get %s: %s", url, resp.Status))
+	}
+
+	size := expectedSize(resp, offset)
+
+	n, err := io.Copy(partfile, m.reader(p.reader(resp.Body)))
+	if err != nil {
+		return false, &retryableError{err: err}
+	}
+
+	if size >= 0 && offset+n != size {
+		return false, fmt.Errorf("%w %s: expected %d bytes, got %d", errSizeMismatch, url, size, offset+n)
+	}
+
+	return size >= 0, nil
+}
+
+// expectedSize returns the full size of the resource being downloaded by resp,
+// or -1 if the server did not tell it.
+func expectedSize(resp *http.Response, offset int64) int64 {
+	if resp.StatusCode == http.StatusPartialContent {
+		if total := contentRangeTotal(resp.Header.Get("Content-Range")); total >= 0 {
+			return total
+		}
+		if resp.ContentLength >= 0 {
+			return offset + resp.ContentLength
+		}
+		return -1
+	}
+	return resp.ContentLength
+}
+
+// contentRangeTotal parses the complete length from a Content-Range header
+// such as "bytes 100-199/200" or "bytes */200". It returns -1 when unknown.
+func contentRangeTotal(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return -1
+	}
+	total, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+// verifyHeader checks the header of the file fname compressed by d.
+func verifyHeader(fname string, d decompressor) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("cannot verify %s: %w", fname, err)
+	}
+	defer f.Close()
+
+	r, err := d.newReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("cannot verify %s: %w", fname, err)
+	}
+	return r.Close()
+}
+
+// verifyStream reads the whole file fname compressed by d to check that it is
+// complete and its checksum matches.
+func verifyStream(fname string, d decompressor) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("cannot verify %s: %w", fname, err)
+	}
+	defer f.Close()
+
+	r, err := d.newReader(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("cannot verify %s: %w", fname, err)
+	}
+
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		r.Close()
+		return fmt.Errorf("cannot verify %s: %w", fname, err)
+	}
+	if err := r.Close(); err != nil {
+		return fmt.Errorf("cannot verify %s: %w", fname, err)
+	}
+	return nil
+}
+
+// verifyDownloads checks that every file of fnames downloaded into dir is
+// present.
+func verifyDownloads(dir string, fnames []string) error {
+	missing := 0
+
+	for _, fname := range fnames {
+		if !fileExists(fname) {
+			slog.Warn("missing file", "file", fname)
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		return fmt.Errorf("verify error: %d of %d files missing in %s", missing, len(fnames), dir)
+	}
+	return nil
+}
+
+func fileExists(fname string) bool {
+	_, err := os.Stat(fname)
+	return err == nil
+}
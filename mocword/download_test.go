@@ -0,0 +1,188 @@
+package mocword
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testIndexPath = "/" + DefaultDatasetVersion + "/eng/eng-1-ngrams_exports.html"
+
+// newTestDataStorage returns a storage listing the 1-gram data files of eng
+// named in files, which are served unless their content is empty.
+func newTestDataStorage(t *testing.T, files map[string]string) *testStorage {
+	t.Helper()
+
+	served := map[string]string{
+		"/" + DefaultDatasetVersion + "/eng/totalcounts-1": "\t2000,10,5,3\n",
+	}
+	var hrefs []string
+	for name, content := range files {
+		hrefs = append(hrefs, name)
+		if content != "" {
+			served["/"+DefaultDatasetVersion+"/eng/"+name] = content
+		}
+	}
+	served[testIndexPath] = testIndexPage(hrefs...)
+	return newTestStorage(t, served)
+}
+
+func gzipString(t testing.TB, content string) string {
+	t.Helper()
+
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestDownloadVerifyFailed(t *testing.T) {
+	s := newTestDataStorage(t, map[string]string{
+		"1-00000-of-00002.gz": gzipString(t, "apple\t2000,3,1\n"),
+		"1-00001-of-00002.gz": "",
+	})
+	opts := DownloadOptions{Source: testSource(s), Dir: t.TempDir(), Verify: true}
+
+	// The missing file both fails and is reported by the verification.
+	for run := 1; run <= 2; run++ {
+		err := Download(context.Background(), opts)
+		if !errors.Is(err, ErrFilesFailed) {
+			t.Errorf("run %d: Download() = %v, want ErrFilesFailed", run, err)
+		}
+		if err == nil || !strings.Contains(err.Error(), "verify error: 1 of 2 files missing") {
+			t.Errorf("run %d: Download() = %v, want a verify error", run, err)
+		}
+	}
+}
+
+func TestDoMidDownloadError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The connection is closed after a part of the announced body.
+		w.Header().Set("Content-Length", "1000")
+		w.Write([]byte(gzipString(t, "apple\t2000,3,1\n"))[:10])
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	url := srv.URL + "/1-00000-of-00001.gz"
+	opts := downloadOptions{client: srv.Client(), dir: dir}
+
+	if err := do(context.Background(), url, opts); err == nil {
+		t.Fatal("do() of a truncated download succeeded, want an error")
+	}
+	if fname := dataFilePath(dir, url, false); fileExists(fname) {
+		t.Errorf("%s exists after a failed download", fname)
+	}
+}
+
+func TestDoExistingFile(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	url := srv.URL + "/1-00000-of-00001.gz"
+	writeGzip(t, dataFilePath(dir, url, false), "apple\t2000,3,1\n")
+
+	if err := do(context.Background(), url, downloadOptions{client: srv.Client(), dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 0 {
+		t.Errorf("do() of an existing file sent %d requests, want 0", requests)
+	}
+}
+
+// clientFunc is an HTTPClient calling itself.
+type clientFunc func(*http.Request) (*http.Response, error)
+
+func (f clientFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestDoWrapsErrors(t *testing.T) {
+	errSend := errors.New("cannot send")
+	url := "https://example.com/1-00000-of-00001.gz"
+
+	tests := []struct {
+		name string
+		opts downloadOptions
+		want error
+	}{
+		{
+			name: "part file",
+			opts: downloadOptions{dir: filepath.Join(t.TempDir(), "missing")},
+			want: fs.ErrNotExist,
+		},
+		{
+			name: "request",
+			opts: downloadOptions{
+				client: clientFunc(func(*http.Request) (*http.Response, error) { return nil, errSend }),
+				dir:    t.TempDir(),
+			},
+			want: errSend,
+		},
+	}
+
+	for _, tt := range tests {
+		err := do(context.Background(), url, tt.opts)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: do() = %v, want it to wrap %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestPrepareOutputDirWrapsErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var perr *fs.PathError
+	if err := prepareOutputDir(filepath.Join(file, "dir")); !errors.As(err, &perr) {
+		t.Errorf("prepareOutputDir() = %v, want it to wrap a *fs.PathError", err)
+	}
+}
+
+func TestDoSizeMismatch(t *testing.T) {
+	body := gzipString(t, "apple\t2000,3,1\n")
+	client := clientFunc(func(req *http.Request) (*http.Response, error) {
+		// The body ends cleanly before the length the response tells.
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Status:        "200 OK",
+			Header:        make(http.Header),
+			ContentLength: int64(len(body)) + 100,
+			Body:          io.NopCloser(strings.NewReader(body)),
+			Request:       req,
+		}, nil
+	})
+
+	dir := t.TempDir()
+	url := "https://example.com/1-00000-of-00001.gz"
+	opts := downloadOptions{client: client, dir: dir}
+
+	if err := do(context.Background(), url, opts); !errors.Is(err, errSizeMismatch) {
+		t.Errorf("do() = %v, want errSizeMismatch", err)
+	}
+	if fname := dataFilePath(dir, url, false); fileExists(fname) {
+		t.Errorf("%s exists after a truncated download", fname)
+	}
+	if part := opts.partPath(url); fileExists(part) {
+		t.Errorf("%s of a truncated download is kept", part)
+	}
+}
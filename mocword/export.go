@@ -0,0 +1,239 @@
+package mocword
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Formats are the export formats of BuildOptions.Format.
+var Formats = []string{"jsonl", "csv", "fst"}
+
+// Compressions are the compressions of the exports of
+// BuildOptions.Compress.
+var Compressions = []string{"none", "gzip", "zstd"}
+
+// exportCompression is the compression of an export: one of Compressions,
+// or the one told by the extension of the export if empty, at level, the
+// default level of the compression if 0.
+type exportCompression struct {
+	name  string
+	level int
+}
+
+// validCompressLevel reports whether level is a level of the compression
+// name, where 0 is the default level.
+func validCompressLevel(name string, level int) bool {
+	switch {
+	case level == 0:
+		return true
+	case name == "gzip":
+		return level >= gzip.BestSpeed && level <= gzip.BestCompression
+	case name == "zstd":
+		return level >= 1 && level <= 22
+	default:
+		return false
+	}
+}
+
+// of returns the compression of the export fname, which is gzip if it ends
+// with ".gz" and zstd if it ends with ".zst" unless c names another.
+func (c exportCompression) of(fname string) string {
+	switch {
+	case c.name != "":
+		return c.name
+	case strings.HasSuffix(fname, ".gz"):
+		return "gzip"
+	case strings.HasSuffix(fname, ".zst"):
+		return "zstd"
+	default:
+		return "none"
+	}
+}
+
+// createExportFile creates fname for writing exported n-grams compressed
+// with c. "-" means stdout.
+func createExportFile(fname string, c exportCompression) (io.WriteCloser, error) {
+	var f io.WriteCloser = nopWriteCloser{os.Stdout}
+	if fname != "-" {
+		var err error
+		if f, err = os.Create(fname); err != nil {
+			return nil, fmt.Errorf("cannot create export file: %w", err)
+		}
+	}
+
+	var cw io.WriteCloser
+	switch c.of(fname) {
+	case "gzip":
+		level := c.level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(f, level)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot create export file: %w", err)
+		}
+		cw = gw
+	case "zstd":
+		level := zstd.SpeedDefault
+		if c.level != 0 {
+			level = zstd.EncoderLevelFromZstd(c.level)
+		}
+		zw, err := zstd.NewWriter(f, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("cannot create export file: %w", err)
+		}
+		cw = zw
+	default:
+		return f, nil
+	}
+	return &compressedFile{cw: cw, f: f}, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressedFile closes both the compressed stream and the underlying file.
+type compressedFile struct {
+	cw io.WriteCloser
+	f  io.WriteCloser
+}
+
+func (c *compressedFile) Write(p []byte) (int, error) { return c.cw.Write(p) }
+
+func (c *compressedFile) Close() error {
+	if err := c.cw.Close(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// jsonlStore is a Store writing each n-gram as a JSON object such as
+// {"tokens":["word1","word2"],"count":1234} per line.
+type jsonlStore struct {
+	wc  io.WriteCloser
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+type jsonlRecord struct {
+	Tokens []string `json:"tokens"`
+	Count  int64    `json:"count"`
+}
+
+func newJSONLStore(fname string, c exportCompression) (*jsonlStore, error) {
+	wc, err := createExportFile(fname, c)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bufio.NewWriter(wc)
+	return &jsonlStore{wc: wc, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (s *jsonlStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	if err := s.enc.Encode(jsonlRecord{Tokens: tokens, Count: count}); err != nil {
+		return fmt.Errorf("cannot write jsonl: %w", err)
+	}
+	return nil
+}
+
+func (s *jsonlStore) Flush(ctx context.Context) error {
+	if err := s.w.Flush(); err != nil {
+		return fmt.Errorf("cannot write jsonl: %w", err)
+	}
+	return ctx.Err()
+}
+
+func (s *jsonlStore) Close() error {
+	if s.wc == nil {
+		return nil
+	}
+	defer func() { s.wc = nil }()
+
+	if err := s.w.Flush(); err != nil {
+		s.wc.Close()
+		return fmt.Errorf("cannot write jsonl: %w", err)
+	}
+	return s.wc.Close()
+}
+
+// csvStore is a Store writing each n-gram as a "token1,...,tokenN,count" row
+// after a header row. Rows of n-grams shorter than maxN are padded with empty
+// tokens so that every row has the same number of fields.
+type csvStore struct {
+	wc     io.WriteCloser
+	w      *csv.Writer
+	maxN   int
+	header bool
+}
+
+func newCSVStore(fname string, c exportCompression, delim rune, maxN int) (*csvStore, error) {
+	wc, err := createExportFile(fname, c)
+	if err != nil {
+		return nil, err
+	}
+
+	w := csv.NewWriter(wc)
+	w.Comma = delim
+	return &csvStore{wc: wc, w: w, maxN: maxN}, nil
+}
+
+func (s *csvStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	if !s.header {
+		header := make([]string, 0, s.maxN+1)
+		for i := 1; i <= s.maxN; i++ {
+			header = append(header, fmt.Sprintf("token%d", i))
+		}
+		if err := s.w.Write(append(header, "count")); err != nil {
+			return fmt.Errorf("cannot write csv: %w", err)
+		}
+		s.header = true
+	}
+
+	record := make([]string, s.maxN+1)
+	copy(record, tokens)
+	record[s.maxN] = strconv.FormatInt(count, 10)
+
+	if err := s.w.Write(record); err != nil {
+		return fmt.Errorf("cannot write csv: %w", err)
+	}
+	return nil
+}
+
+func (s *csvStore) Flush(ctx context.Context) error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return fmt.Errorf("cannot write csv: %w", err)
+	}
+	return ctx.Err()
+}
+
+func (s *csvStore) Close() error {
+	if s.wc == nil {
+		return nil
+	}
+	defer func() { s.wc = nil }()
+
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.wc.Close()
+		return fmt.Errorf("cannot write csv: %w", err)
+	}
+	return s.wc.Close()
+}
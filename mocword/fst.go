@@ -0,0 +1,172 @@
+package mocword
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/vellum"
+)
+
+// fstStore is a Store writing the n-grams as a finite state transducer of
+// vellum, which maps the tokens joined with spaces to their counts. The keys
+// of an FST must be added in order, so the n-grams are held in memory and
+// the FST is written by finish once the build is complete. The counts of an
+// n-gram inserted more than once are summed.
+//
+// The FST is written into a temporary file renamed to fname, so that the
+// export of a failed build, which is closed unfinished, is never left at
+// fname.
+type fstStore struct {
+	fname  string
+	f      *os.File
+	counts map[string]int64
+}
+
+func newFSTStore(fname string) (*fstStore, error) {
+	s := &fstStore{fname: fname, counts: make(map[string]int64)}
+	if fname != "-" {
+		f, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create export file: %w", err)
+		}
+		s.f = f
+	}
+	return s, nil
+}
+
+func (s *fstStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	s.counts[strings.Join(tokens, " ")] += count
+	return nil
+}
+
+func (s *fstStore) Flush(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// finish writes the FST to fname.
+func (s *fstStore) finish() error {
+	if s.counts == nil {
+		return nil
+	}
+
+	var w io.Writer = os.Stdout
+	if s.f != nil {
+		w = s.f
+	}
+	if err := writeFST(w, s.counts); err != nil {
+		return err
+	}
+
+	if s.f != nil {
+		if err := s.f.Chmod(0644); err != nil {
+			return fmt.Errorf("cannot write fst: %w", err)
+		}
+		if err := s.f.Close(); err != nil {
+			return fmt.Errorf("cannot write fst: %w", err)
+		}
+		if err := os.Rename(s.f.Name(), s.fname); err != nil {
+			return fmt.Errorf("cannot write fst: %w", err)
+		}
+	}
+	s.f, s.counts = nil, nil
+	return nil
+}
+
+// Close discards the FST unless it is finished.
+func (s *fstStore) Close() error {
+	if s.f != nil {
+		s.f.Close()
+		os.Remove(s.f.Name())
+	}
+	s.f, s.counts = nil, nil
+	return nil
+}
+
+// writeFST writes the FST of counts to w.
+func writeFST(w io.Writer, counts map[string]int64) error {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	b, err := vellum.New(bw, nil)
+	if err != nil {
+		return fmt.Errorf("cannot write fst: %w", err)
+	}
+	for _, key := range keys {
+		if err := b.Insert([]byte(key), uint64(counts[key])); err != nil {
+			return fmt.Errorf("cannot write fst: %w", err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		return fmt.Errorf("cannot write fst: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("cannot write fst: %w", err)
+	}
+	return nil
+}
+
+// Completion is an n-gram found by CompleteFST.
+type Completion struct {
+	Tokens []string
+	Count  int64
+}
+
+// CompleteFST returns up to limit n-grams of the FST exported into fname with
+// Format "fst" which start with prefix, in decreasing order of count. A
+// prefix ending with a space completes the next token, e.g. "the " finds
+// "the cat" and "the dog", while "the c" also finds "the city". All the
+// matches are found if limit is not positive.
+func CompleteFST(fname, prefix string, limit int) ([]Completion, error) {
+	fst, err := vellum.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open fst: %w", err)
+	}
+	defer fst.Close()
+
+	var completions []Completion
+
+	it, err := fst.Iterator([]byte(prefix), prefixEnd([]byte(prefix)))
+	for err == nil {
+		key, count := it.Current()
+		completions = append(completions, Completion{
+			Tokens: strings.Split(string(key), " "),
+			Count:  int64(count),
+		})
+		err = it.Next()
+	}
+	if err != vellum.ErrIteratorDone {
+		return nil, fmt.Errorf("cannot search fst: %w", err)
+	}
+
+	sort.SliceStable(completions, func(i, j int) bool {
+		return completions[i].Count > completions[j].Count
+	})
+	if limit > 0 && len(completions) > limit {
+		completions = completions[:limit]
+	}
+	return completions, nil
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package mocword
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFSTStoreFinish(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "ngrams.fst")
+
+	// A store closed unfinished, as by a failed build, leaves no export.
+	s, err := newFSTStore(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Insert(ctx, []string{"the", "cat"}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("dir of an unfinished export = %v, %v, want empty", entries, err)
+	}
+
+	s, err = newFSTStore(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Completion{{[]string{"the", "cat"}, 3}, {[]string{"the", "dog"}, 5}} {
+		if err := s.Insert(ctx, c.Tokens, c.Count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := CompleteFST(fname, "the ", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Count != 5 || got[1].Count != 3 {
+		t.Errorf("CompleteFST() = %v, want the dog 5 and the cat 3", got)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Errorf("dir of a finished export = %v, %v, want the export only", entries, err)
+	}
+}
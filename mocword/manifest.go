@@ -0,0 +1,138 @@
+package mocword
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const manifestFileName = "manifest.json"
+
+// manifest records the urls which have been completely downloaded, and the
+// urls which have been completely built into each build target, so that a
+// rerun can skip them.
+type manifest struct {
+	Downloaded map[string]time.Time            `json:"downloaded"`
+	Built      map[string]map[string]time.Time `json:"built"`
+
+	// Version is the BuildInfo of the tool which last saved the manifest.
+	Version string `json:"version,omitempty"`
+
+	fname string
+
+	// target identifies the build destination of this run. Builds are not
+	// recorded if it is empty.
+	target string
+}
+
+// loadManifest loads the manifest in dir. A missing manifest is empty.
+func loadManifest(dir, target string) (*manifest, error) {
+	m := &manifest{
+		Downloaded: make(map[string]time.Time),
+		Built:      make(map[string]map[string]time.Time),
+		fname:      filepath.Join(dir, manifestFileName),
+		target:     target,
+	}
+
+	buf, err := ioutil.ReadFile(m.fname)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(buf, m); err != nil {
+		return nil, fmt.Errorf("cannot load manifest %s: %w", m.fname, err)
+	}
+	if m.Downloaded == nil {
+		m.Downloaded = make(map[string]time.Time)
+	}
+	if m.Built == nil {
+		m.Built = make(map[string]map[string]time.Time)
+	}
+
+	return m, nil
+}
+
+func (m *manifest) isDownloaded(url string) bool {
+	_, ok := m.Downloaded[url]
+	return ok
+}
+
+func (m *manifest) markDownloaded(url string) error {
+	m.Downloaded[url] = time.Now()
+	return m.save()
+}
+
+func (m *manifest) isBuilt(url string) bool {
+	if m.target == "" {
+		return false
+	}
+	_, ok := m.Built[m.target][url]
+	return ok
+}
+
+func (m *manifest) markBuilt(url string) error {
+	if m.target == "" {
+		return nil
+	}
+	if m.Built[m.target] == nil {
+		m.Built[m.target] = make(map[string]time.Time)
+	}
+	m.Built[m.target][url] = time.Now()
+	return m.save()
+}
+
+// resetBuilt forgets the urls built into the build target.
+func (m *manifest) resetBuilt() error {
+	if m.target == "" {
+		return nil
+	}
+	delete(m.Built, m.target)
+	return m.save()
+}
+
+// moveBuilt records the urls built into the build target as built into
+// target instead, such as after the database built is renamed.
+func (m *manifest) moveBuilt(target string) error {
+	if m.target == "" {
+		return nil
+	}
+	m.Built[target] = m.Built[m.target]
+	delete(m.Built, m.target)
+	m.target = target
+	return m.save()
+}
+
+// save writes the manifest atomically.
+func (m *manifest) save() error {
+	m.Version = ReadBuildInfo().String()
+
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return fmt.Errorf("cannot save manifest: %w", err)
+	}
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(m.fname), manifestFileName)
+	if err != nil {
+		return fmt.Errorf("cannot save manifest: %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	if _, err := tmpfile.Write(buf); err != nil {
+		return fmt.Errorf("cannot save manifest: %w", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		return fmt.Errorf("cannot save manifest: %w", err)
+	}
+
+	if err := os.Rename(tmpfile.Name(), m.fname); err != nil {
+		return fmt.Errorf("cannot save manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package mocword
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// MergeOptions configures Merge.
+type MergeOptions struct {
+	// Sources are the SQLite databases built by Build to merge. Their words
+	// may be normalized into vocab or not.
+	Sources []string
+
+	// Output is the SQLite database to merge into. It may already hold
+	// n-grams, which are merged too.
+	Output string
+
+	// NormalizeVocab stores the words of Output as vocab ids as
+	// BuildOptions.NormalizeVocab does.
+	NormalizeVocab bool
+
+	// SkipIndex does not create the prefix indexes after merging.
+	SkipIndex bool
+
+	// BatchSize is the number of rows inserted per transaction, 10000 if 0.
+	BatchSize int
+
+	// TablePrefix is the BuildOptions.TablePrefix of the tables of Sources,
+	// which are merged into the tables of Output prefixed with it.
+	TablePrefix string
+}
+
+// Merge merges the n-gram tables of opts.Sources into opts.Output, summing
+// the counts of identical token sequences. The rows are streamed from the
+// sources, and the n-grams found in more than one source are logged as
+// conflicts per table.
+func Merge(ctx context.Context, opts MergeOptions) error {
+	if opts.Output == "" {
+		return errors.New("cannot merge: no output")
+	}
+	for _, src := range opts.Sources {
+		if src == opts.Output {
+			return fmt.Errorf("cannot merge: source is the output: %s", src)
+		}
+	}
+	if opts.TablePrefix != "" && !ValidTablePrefix(opts.TablePrefix) {
+		return fmt.Errorf("cannot merge: invalid table prefix: %q", opts.TablePrefix)
+	}
+	t := tableNames{opts.TablePrefix}
+
+	batchSize := opts.BatchSize
+	if batchSize < 1 {
+		batchSize = 10000
+	}
+
+	store, err := newSQLiteStore(opts.Output, sqliteOptions{
+		synchronous:    "NORMAL",
+		batchSize:      batchSize,
+		normalizeVocab: opts.NormalizeVocab,
+		upsert:         true,
+		tables:         t,
+	})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// read and before are the rows per n read from the sources and in the
+	// output before merging, which tell the conflicts.
+	read := make(map[int]int64)
+	before := make(map[int]int64)
+	for n := 1; n <= len(ngramTableNames); n++ {
+		if before[n], err = countRows(store.db, t, n); err != nil {
+			return err
+		}
+	}
+
+	for _, src := range opts.Sources {
+		if err := mergeSource(ctx, store, src, t, read); err != nil {
+			return err
+		}
+	}
+
+	var ngrams []int
+	for n := 1; n <= len(ngramTableNames); n++ {
+		if read[n] == 0 {
+			continue
+		}
+		ngrams = append(ngrams, n)
+
+		after, err := countRows(store.db, t, n)
+		if err != nil {
+			return err
+		}
+		table, _ := t.ngram(n)
+		slog.Info("merged", "table", table, "rows", after, "conflicts", read[n]-(after-before[n]))
+	}
+
+	if !opts.SkipIndex {
+		if err := store.createIndexes(ngrams); err != nil {
+			return err
+		}
+	}
+
+	return store.Close()
+}
+
+// mergeSource inserts every n-gram of the tables t of the database src into
+// store, adding the numbers of rows read per n to read.
+func mergeSource(ctx context.Context, store Store, src string, t tableNames, read map[int]int64) error {
+	db, err := sql.Open("sqlite3", "file:"+src+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("cannot merge %s: %w", src, err)
+	}
+	defer db.Close()
+
+	vocab, err := tableExists(db, t.vocab())
+	if err != nil {
+		return fmt.Errorf("cannot merge %s: %w", src, err)
+	}
+
+	for n := 1; n <= len(ngramTableNames); n++ {
+		table, _ := t.ngram(n)
+		exists, err := tableExists(db, table)
+		if err != nil {
+			return fmt.Errorf("cannot merge %s: %w", src, err)
+		}
+		if !exists {
+			continue
+		}
+
+		rows, err := db.QueryContext(ctx, selectNgramQuery(t, n, vocab))
+		if err != nil {
+			return fmt.Errorf("cannot merge %s: %w", src, err)
+		}
+
+		tokens := make([]string, n)
+		dest := make([]interface{}, 0, n+1)
+		for i := range tokens {
+			dest = append(dest, &tokens[i])
+		}
+		var count int64
+		dest = append(dest, &count)
+
+		for rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return fmt.Errorf("cannot merge %s: %w", src, err)
+			}
+			if err := store.Insert(ctx, tokens, count); err != nil {
+				rows.Close()
+				return fmt.Errorf("cannot merge %s: %w", src, err)
+			}
+			read[n]++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return fmt.Errorf("cannot merge %s: %w", src, err)
+		}
+	}
+
+	if err := store.Flush(ctx); err != nil {
+		return fmt.Errorf("cannot merge %s: %w", src, err)
+	}
+	return nil
+}
+
+// selectNgramQuery selects the words and count of every row of the table of
+// n-grams of t, resolving the ids of vocab.
+func selectNgramQuery(t tableNames, n int, vocab bool) string {
+	table, _ := t.ngram(n)
+
+	if !vocab {
+		return fmt.Sprintf("SELECT %s, count FROM %s", strings.Join(wordColumns(n), ", "), table)
+	}
+
+	var cols, joins []string
+	for i := 1; i <= n; i++ {
+		cols = append(cols, fmt.Sprintf("v%d.word", i))
+		joins = append(joins, fmt.Sprintf("JOIN %s v%d ON v%d.id = g.word%d", t.vocab(), i, i, i))
+	}
+	return fmt.Sprintf("SELECT %s, g.count FROM %s g %s", strings.Join(cols, ", "), table, strings.Join(joins, " "))
+}
+
+func tableExists(db *sql.DB, table string) (bool, error) {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// countRows returns the number of rows of the table of n-grams t of db, which
+// is 0 if it does not exist.
+func countRows(db *sql.DB, t tableNames, n int) (int64, error) {
+	table, _ := t.ngram(n)
+
+	exists, err := tableExists(db, table)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var count int64
+	if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&count); err != nil {
+		return 0, fmt.Errorf("cannot count rows of %s: %w", table, err)
+	}
+	return count, nil
+}
@@ -0,0 +1,73 @@
+package mocword
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestDB writes the 1-grams of counts into the SQLite database fname
+// built with opts.
+func writeTestDB(t *testing.T, fname string, opts sqliteOptions, counts map[string]int64) {
+	t.Helper()
+	ctx := context.Background()
+
+	s, err := newSQLiteStore(fname, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for word, count := range counts {
+		if err := s.Insert(ctx, []string{word}, count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeTablePrefix(t *testing.T) {
+	dir := t.TempDir()
+	srcs := []string{filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")}
+	writeTestDB(t, srcs[0], sqliteOptions{tables: tableNames{"p"}}, map[string]int64{"apple": 1, "banana": 2})
+	writeTestDB(t, srcs[1], sqliteOptions{tables: tableNames{"p"}}, map[string]int64{"cherry": 3})
+
+	out := filepath.Join(dir, "out.db")
+	err := Merge(context.Background(), MergeOptions{Sources: srcs, Output: out, TablePrefix: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{"apple": 1, "banana": 2, "cherry": 3}
+	assertCounts(t, out, readTableCounts(t, out, "p_one_grams"), want)
+}
+
+func TestMergeInvalidTablePrefix(t *testing.T) {
+	dir := t.TempDir()
+	err := Merge(context.Background(), MergeOptions{
+		Sources:     []string{filepath.Join(dir, "a.db")},
+		Output:      filepath.Join(dir, "out.db"),
+		TablePrefix: "Bad-Prefix",
+	})
+	if err == nil {
+		t.Error("Merge with an invalid table prefix succeeded, want an error")
+	}
+}
+
+func TestMergeSumsCounts(t *testing.T) {
+	dir := t.TempDir()
+	srcs := []string{filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")}
+	writeTestDB(t, srcs[0], sqliteOptions{}, map[string]int64{"apple": 1, "banana": 2})
+	writeTestDB(t, srcs[1], sqliteOptions{normalizeVocab: true}, map[string]int64{"banana": 3, "cherry": 4})
+
+	out := filepath.Join(dir, "out.db")
+	if err := Merge(context.Background(), MergeOptions{Sources: srcs, Output: out}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{"apple": 1, "banana": 5, "cherry": 4}
+	assertCounts(t, out, readCounts(t, out), want)
+}
@@ -0,0 +1,139 @@
+package mocword
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the Prometheus metrics of downloads and builds, labeled by
+// language and ngram. A nil *Metrics records nothing.
+type Metrics struct {
+	downloadedBytes *prometheus.CounterVec
+	completedFiles  *prometheus.CounterVec
+	failedFiles     *prometheus.CounterVec
+	activeFiles     *prometheus.GaugeVec
+	insertedRows    *prometheus.CounterVec
+	buildDuration   *prometheus.GaugeVec
+}
+
+// NewMetrics returns Metrics registered to reg.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	labels := []string{"language", "ngram"}
+
+	m := &Metrics{
+		downloadedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mocword_downloaded_bytes_total",
+			Help: "Bytes of the data files downloaded.",
+		}, labels),
+		completedFiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mocword_completed_files_total",
+			Help: "Data files downloaded or built.",
+		}, append(labels, "stage")),
+		failedFiles: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mocword_failed_files_total",
+			Help: "Data files which could not be downloaded.",
+		}, labels),
+		activeFiles: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mocword_active_files",
+			Help: "Data files being downloaded or parsed at once.",
+		}, append(labels, "stage")),
+		insertedRows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mocword_inserted_rows_total",
+			Help: "N-grams inserted into the stores.",
+		}, labels),
+		buildDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mocword_build_duration_seconds",
+			Help: "Duration of the last build of the data files.",
+		}, labels),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.downloadedBytes, m.completedFiles, m.failedFiles, m.activeFiles, m.insertedRows, m.buildDuration,
+	} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// combo returns the metrics of lang and ngram, which is nil if m is nil.
+func (m *Metrics) combo(lang, ngram string) *comboMetrics {
+	if m == nil {
+		return nil
+	}
+	return &comboMetrics{m: m, lang: lang, ngram: ngram}
+}
+
+// comboMetrics records the Metrics of a language and an ngram. A nil
+// *comboMetrics records nothing.
+type comboMetrics struct {
+	m     *Metrics
+	lang  string
+	ngram string
+}
+
+// reader returns r counting the bytes read as downloaded.
+func (c *comboMetrics) reader(r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	return &metricsReader{r: r, bytes: c.m.downloadedBytes.WithLabelValues(c.lang, c.ngram)}
+}
+
+// fileStarted and fileStopped count a file being processed in stage,
+// "download" or "build", at the moment.
+func (c *comboMetrics) fileStarted(stage string) {
+	if c == nil {
+		return
+	}
+	c.m.activeFiles.WithLabelValues(c.lang, c.ngram, stage).Inc()
+}
+
+func (c *comboMetrics) fileStopped(stage string) {
+	if c == nil {
+		return
+	}
+	c.m.activeFiles.WithLabelValues(c.lang, c.ngram, stage).Dec()
+}
+
+func (c *comboMetrics) fileCompleted(stage string) {
+	if c == nil {
+		return
+	}
+	c.m.completedFiles.WithLabelValues(c.lang, c.ngram, stage).Inc()
+}
+
+func (c *comboMetrics) fileFailed() {
+	if c == nil {
+		return
+	}
+	c.m.failedFiles.WithLabelValues(c.lang, c.ngram).Inc()
+}
+
+func (c *comboMetrics) rowInserted() {
+	if c == nil {
+		return
+	}
+	c.m.insertedRows.WithLabelValues(c.lang, c.ngram).Inc()
+}
+
+func (c *comboMetrics) built(d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.m.buildDuration.WithLabelValues(c.lang, c.ngram).Set(d.Seconds())
+}
+
+type metricsReader struct {
+	r     io.Reader
+	bytes prometheus.Counter
+}
+
+func (r *metricsReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.bytes.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,986 @@
+// Package mocword downloads the Google Books Ngram dataset and builds n-gram
+// databases from it.
+package mocword
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Languages are the languages of the dataset. They are the codes of the
+// storage, which names both the directory and the file prefix of a language
+// with its code as is: the dialects are hyphenated as "eng-us" while Chinese is
+// "chi_sim".
+var Languages = []string{
+	"eng",
+	"eng-us",
+	"eng-gb",
+	"eng-fiction",
+	"chi_sim",
+	"fre",
+	"ger",
+	"heb",
+	"ita",
+	"rus",
+	"spa",
+}
+
+// Ngrams are the n-gram sizes of the dataset.
+var Ngrams = []string{"1", "2", "3", "4", "5"}
+
+// DefaultDatasetVersion is the dataset version used if none is given.
+const DefaultDatasetVersion = "20200217"
+
+// DefaultBaseURL is the base url of the dataset on Google's storage.
+const DefaultBaseURL = "https://storage.googleapis.com/books/ngrams/books/"
+
+// DefaultMaxIndexSize is the max size in bytes of an index page used if none
+// is given.
+const DefaultMaxIndexSize = 8 << 20
+
+// HTTPClient sends HTTP requests. *http.Client satisfies it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Source selects the files of the dataset and how they are fetched.
+type Source struct {
+	// Client sends every request. It is http.DefaultClient if nil, and may be
+	// replaced with a fake or a client of an httptest.Server.
+	Client HTTPClient
+
+	// BaseURL is the base url of the dataset storage, DefaultBaseURL if empty.
+	BaseURL string
+
+	// DatasetVersion is a release date as YYYYMMDD or "latest", and
+	// DefaultDatasetVersion if empty.
+	DatasetVersion string
+
+	// Languages and Ngrams are elements of the package variables of the same
+	// names.
+	Languages []string
+	Ngrams    []string
+
+	// Retry tells how the downloads and index pages failing on transient
+	// errors are tried again. The zero value does not retry.
+	Retry RetryPolicy
+
+	// Timeout is the timeout of each download attempt. An interrupted
+	// download is resumed on retry. 0 means no timeout.
+	Timeout time.Duration
+
+	// Strict fails with ErrUnavailable if a selected language does not publish
+	// a selected ngram, or its index page lists no data file. Such pairs are
+	// otherwise skipped with a warning. Download also stops at the first data
+	// file which cannot be downloaded.
+	Strict bool
+
+	// MaxFiles limits the data files of each language and ngram to the first
+	// MaxFiles urls if positive, e.g. for a quick test of a pipeline. A later
+	// run without it fetches the rest.
+	MaxFiles int
+
+	// MaxIndexSize is the max size in bytes of an index page, which fails to be
+	// fetched if it is larger, so that a rogue mirror cannot exhaust the
+	// memory. It is DefaultMaxIndexSize if 0.
+	MaxIndexSize int64
+
+	// Metrics records the downloads and builds of the run if not nil.
+	Metrics *Metrics
+}
+
+func (s Source) validate() error {
+	for _, lang := range s.Languages {
+		if !contains(Languages, lang) {
+			return fmt.Errorf("invalid language: %q", lang)
+		}
+	}
+	for _, ngram := range s.Ngrams {
+		if !contains(Ngrams, ngram) {
+			return fmt.Errorf("invalid ngram: %q", ngram)
+		}
+	}
+	if s.DatasetVersion != "" && s.DatasetVersion != "latest" && !isDatasetVersion(s.DatasetVersion) {
+		return fmt.Errorf("invalid dataset version: %q", s.DatasetVersion)
+	}
+	if s.MaxIndexSize < 0 {
+		return fmt.Errorf("invalid max index size: %d", s.MaxIndexSize)
+	}
+	return nil
+}
+
+// dataset resolves the defaults and the "latest" version of s.
+func (s Source) dataset(ctx context.Context) dataset {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ds := dataset{
+		client:  client,
+		baseURL: s.BaseURL,
+		version: s.DatasetVersion,
+
+		maxIndexSize: s.MaxIndexSize,
+		retry:        s.Retry,
+	}
+	if ds.baseURL == "" {
+		ds.baseURL = DefaultBaseURL
+	}
+	if ds.version == "" {
+		ds.version = DefaultDatasetVersion
+	}
+	if ds.maxIndexSize == 0 {
+		ds.maxIndexSize = DefaultMaxIndexSize
+	}
+	ds.version = resolveDatasetVersion(ctx, client, ds.version)
+
+	return ds
+}
+
+// combos lists the combos of the selected languages and ngrams of ds.
+func (s Source) combos(ctx context.Context, ds dataset) ([]combo, error) {
+	combos, err := listCombos(ctx, ds, s.Languages, s.Ngrams, s.Strict)
+	if err != nil {
+		return nil, err
+	}
+	return s.limitFiles(combos), nil
+}
+
+// limitFiles truncates the urls of each combo to MaxFiles.
+func (s Source) limitFiles(combos []combo) []combo {
+	if s.MaxFiles <= 0 {
+		return combos
+	}
+	for i := range combos {
+		if len(combos[i].urls) > s.MaxFiles {
+			combos[i].urls = combos[i].urls[:s.MaxFiles]
+		}
+	}
+	return combos
+}
+
+func (s Source) downloadOptions(client HTTPClient, dir string) downloadOptions {
+	return downloadOptions{
+		client:  client,
+		dir:     dir,
+		retry:   s.Retry,
+		timeout: s.Timeout,
+	}
+}
+
+// ListURLs returns every data url of the selected languages and ngrams.
+func ListURLs(ctx context.Context, src Source) ([]string, error) {
+	if err := src.validate(); err != nil {
+		return nil, fmt.Errorf("cannot list urls: %w", err)
+	}
+
+	combos, err := src.combos(ctx, src.dataset(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	for _, c := range combos {
+		urls = append(urls, c.urls...)
+	}
+	return urls, nil
+}
+
+// DownloadOptions configures Download.
+type DownloadOptions struct {
+	Source
+
+	// Dir is the directory to save the downloaded files into. It is created
+	// if missing. The data files are laid out as on the dataset storage in
+	// "<Dir>/<version>/<language>/<ngram>/", while the manifest, checksums
+	// and total counts are in Dir itself. A file recorded as downloaded in
+	// the manifest but missing from its subdirectory, such as one of the
+	// former flat layout, is downloaded again.
+	Dir string
+
+	// TmpDir holds the partial downloads instead of Dir, e.g. on a faster
+	// local disk. A completed file is moved into Dir, by copying it if TmpDir
+	// is on another filesystem.
+	TmpDir string
+
+	// ChecksumFile is a file of "<sha256>  <url>" lines to verify the
+	// downloaded files against.
+	ChecksumFile string
+
+	// WriteChecksums writes the SHA-256 of the downloaded files to
+	// checksums.txt in Dir unless ChecksumFile is given.
+	WriteChecksums bool
+
+	// VerifyGzip decompresses each download completely to check its
+	// integrity before accepting it. Otherwise only the header of the gzip or
+	// zstd file is checked.
+	VerifyGzip bool
+
+	// Decompress saves the data files decompressed without their ".gz" or
+	// ".zst" suffix, e.g. for grep. Build reads either form.
+	Decompress bool
+
+	// Verify checks that every listed file exists in Dir after downloading,
+	// whether downloaded by this run or a previous one, even if some failed.
+	Verify bool
+
+	// Force downloads every selected data file again, ignoring the files of
+	// Dir and the manifest. A partial download is discarded instead of
+	// resumed, so that an interrupted forced run which is run again with
+	// Force starts over, while one run without it resumes the files left.
+	// Each new file replaces the old one only once it is complete.
+	Force bool
+
+	// Report records the selection and the outcome of every data file if not
+	// nil.
+	Report *Report
+
+	// NoSpaceCheck skips the check of the free disk space of Dir, which
+	// counts the downloads as several times their size with Decompress.
+	NoSpaceCheck bool
+
+	// Progress reports the download progress every ProgressInterval, or
+	// every 10 seconds if it is 0.
+	Progress         bool
+	ProgressInterval time.Duration
+}
+
+// ErrFilesFailed is wrapped by the error of Download if some data files cannot
+// be downloaded while the others are, and by the error of Build if
+// BuildOptions.SkipMissing leaves some out.
+var ErrFilesFailed = errors.New("some files failed")
+
+// Download downloads the data files and the total counts of the selected
+// languages and ngrams into opts.Dir. Files which are already downloaded are
+// skipped, and partially downloaded ones are resumed. A file which cannot be
+// downloaded is reported with ErrFilesFailed after trying the others unless
+// opts.Strict. The size to download is estimated with HEAD requests up front.
+func Download(ctx context.Context, opts DownloadOptions) (err error) {
+	start := time.Now()
+
+	if err := opts.validate(); err != nil {
+		return fmt.Errorf("cannot download: %w", err)
+	}
+
+	ds := opts.dataset(ctx)
+
+	if err := prepareOutputDir(opts.Dir); err != nil {
+		return err
+	}
+
+	m, err := loadManifest(opts.Dir, "")
+	if err != nil {
+		return err
+	}
+
+	dlOpts := opts.downloadOptions(ds.client, opts.Dir)
+	dlOpts.verifyGzip = opts.VerifyGzip
+	if opts.TmpDir != "" {
+		if err := prepareOutputDir(opts.TmpDir); err != nil {
+			return err
+		}
+		dlOpts.tmpDir = opts.TmpDir
+	}
+	if opts.ChecksumFile != "" {
+		if dlOpts.checksums, err = loadChecksums(opts.ChecksumFile); err != nil {
+			return err
+		}
+	}
+
+	// The checksums written with WriteChecksums are collected by do.
+	if opts.WriteChecksums && opts.ChecksumFile == "" {
+		dlOpts.computed = make(map[string]string)
+		if prev, err := loadChecksums(filepath.Join(opts.Dir, checksumsFileName)); err == nil {
+			dlOpts.computed = prev
+		}
+	}
+	dlOpts.decompress = opts.Decompress
+	dlOpts.force = opts.Force
+
+	opts.Report.start(ds.version, opts.Languages, opts.Ngrams)
+
+	combos, err := opts.combos(ctx, ds)
+	if err != nil {
+		return err
+	}
+
+	est, err := estimateDownload(ctx, dlOpts, ds.version, combos)
+	if err != nil {
+		return err
+	}
+	slog.Info("download estimate", "files", est.files, "bytes", est.bytes, "size", formatBytes(est.bytes))
+	if !opts.NoSpaceCheck {
+		if err := checkDiskSpace(opts.Dir, est.space(opts.Decompress)); err != nil {
+			return err
+		}
+	}
+
+	// The progress also counts the downloads for the summary when it is not
+	// reported.
+	dlOpts.progress = newProgress(est.files, est.bytes)
+	defer func() { logSummary(ctx, dlOpts.progress, time.Since(start), err) }()
+	if opts.Progress {
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		dlOpts.progress.run(interval)
+		defer dlOpts.progress.Stop()
+	}
+
+	// listed are the data files of every listed url, which Verify checks.
+	var listed []string
+	var failed []error
+
+	for _, c := range combos {
+		cOpts, err := dlOpts.comboOptions(ds.version, c)
+		if err != nil {
+			return err
+		}
+		cOpts.metrics = opts.Metrics.combo(c.lang, c.ngram)
+
+		for _, url := range c.urls {
+			fname := findDataFile(cOpts.dir, url)
+			if !opts.Force && m.isDownloaded(url) && fileExists(fname) {
+				opts.Report.file(url, FileSkipped, fname, 0, nil)
+				continue
+			}
+			cOpts.metrics.fileStarted("download")
+			fileStart := time.Now()
+			err := do(ctx, url, cOpts)
+			cOpts.metrics.fileStopped("download")
+			if err != nil {
+				opts.Report.file(url, FileFailed, cOpts.partPath(url), time.Since(fileStart), err)
+				// A failed file does not stop the others unless the run is
+				// cancelled.
+				if ctx.Err() != nil {
+					return err
+				}
+				if opts.Strict {
+					return err
+				}
+				slog.Error("download failed", "url", url, "error", err)
+				cOpts.progress.fileFailed()
+				cOpts.metrics.fileFailed()
+				failed = append(failed, err)
+				continue
+			}
+			fname = findDataFile(cOpts.dir, url)
+			opts.Report.file(url, FileDownloaded, fname, time.Since(fileStart), nil)
+			cOpts.progress.fileDone()
+			cOpts.metrics.fileCompleted("download")
+			if cOpts.computed != nil {
+				if err := saveChecksums(filepath.Join(opts.Dir, checksumsFileName), cOpts.computed); err != nil {
+					return err
+				}
+			}
+			if err := m.markDownloaded(url); err != nil {
+				return err
+			}
+		}
+
+		if err := downloadTotalCounts(ctx, ds, opts.Dir, c.lang, c.ngram); err != nil {
+			return err
+		}
+
+		for _, url := range c.urls {
+			listed = append(listed, findDataFile(cOpts.dir, url))
+		}
+	}
+
+	var errs []error
+	if len(failed) > 0 {
+		errs = append(errs, fmt.Errorf("%w: cannot download %d files: %w", ErrFilesFailed, len(failed), errors.Join(failed...)))
+	}
+	if opts.Verify {
+		if err := verifyDownloads(opts.Dir, listed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// downloadTotalCounts saves the total counts of lang and ngram into dir.
+func downloadTotalCounts(ctx context.Context, ds dataset, dir, lang, ngram string) error {
+	counts, err := fetchTotalCounts(ctx, ds, lang, ngram)
+	if err != nil {
+		return err
+	}
+	if err := saveTotalCounts(dir, lang, ngram, counts); err != nil {
+		return err
+	}
+	slog.Info("saved total counts", "language", lang, "ngram", ngram, "matches", totalMatchCount(counts))
+	return nil
+}
+
+// BuildOptions configures Build.
+type BuildOptions struct {
+	Source
+
+	// Dir is the directory of the files downloaded by Download, and of the
+	// manifest recording the built files.
+	Dir string
+
+	// SourceDir builds from the data files under it, such as a mirror of the
+	// dataset storage, instead of the downloads of Dir without any request.
+	// The language of a file is told by the nearest parent directory named
+	// after a language, or by Languages if a single language is selected.
+	// The manifest is still kept in Dir.
+	SourceDir string
+
+	// Stream builds directly from the downloads without saving the data
+	// files into Dir. It is not resumable within a file. The total counts
+	// are saved into Dir as Download does.
+	Stream bool
+
+	// DB is the path of the SQLite database to build, and DSN the PostgreSQL
+	// connection string of the database to build instead. A sources table of
+	// either records the rows committed from each data file in the same
+	// transactions as them, so that an interrupted build skips the files
+	// completely loaded and resumes the one in progress after its committed
+	// rows. Each ShardByInitial database records the rows committed into it.
+	DB  string
+	DSN string
+
+	// Format also exports the built ngrams to Export in one of Formats.
+	// Export "-" is stdout, and the export is compressed by Compress. CSVDelim is
+	// the field delimiter of csv, ',' if 0. fst holds the ngrams in memory
+	// until the build ends and writes them as an FST searched by CompleteFST,
+	// which cannot be compressed since CompleteFST opens it as is. The export is written anew from every selected data file by each build.
+	Format   string
+	Export   string
+	CSVDelim rune
+
+	// Compress compresses the export with one of Compressions, or with gzip if
+	// Export ends with ".gz" and zstd if it ends with ".zst" if empty. The
+	// export is streamed through the compressor as it is written. CompressLevel
+	// is the level of gzip from 1 to 9 or zstd from 1 to 22, and the default
+	// one if 0.
+	Compress      string
+	CompressLevel int
+
+	// BatchSize is the number of rows inserted per transaction, 10000 if 0.
+	BatchSize int
+
+	// MinCount skips ngrams whose total match count is below it.
+	MinCount int64
+
+	// YearStart and YearEnd are the inclusive range of the years whose counts
+	// are summed. YearEnd 0 means no upper bound.
+	YearStart int
+	YearEnd   int
+
+	// SkipIndex does not create indexes after building the database.
+	SkipIndex bool
+
+	// Optimize runs VACUUM and ANALYZE on the SQLite database after the
+	// indexes are built.
+	Optimize bool
+
+	// StripPOS strips part-of-speech tags such as book_NOUN and _NOUN_ from
+	// tokens.
+	StripPOS bool
+
+	// KeepBoundaries keeps the sentence boundary tokens _START_ and _END_,
+	// such as for predicting the first word of a sentence. They are stripped
+	// otherwise, together with the n-grams holding them, which would be
+	// shorter or empty without them.
+	KeepBoundaries bool
+
+	// SkipHeader skips the first line of each data file if it is not an
+	// n-gram record, such as the header row of a file re-exported by a mirror.
+	SkipHeader bool
+
+	// SkipMissing builds the downloaded data files of Dir leaving out the
+	// missing ones, such as the ones Download failed, instead of failing. The
+	// error of Build then wraps ErrFilesFailed, and a later build adds them.
+	SkipMissing bool
+
+	// Lowercase merges tokens case-insensitively, which uses more memory for
+	// merging.
+	Lowercase bool
+
+	// ShardByInitial splits DB into a database per initial letter of the
+	// first token, named by ShardPath.
+	ShardByInitial bool
+
+	// NormalizeVocab stores each word once in a vocab (id, word) table of DB,
+	// and the words of the n-gram tables as integer ids referencing it, which
+	// shrinks the database. The n-grams are then looked up through vocab, e.g.
+	//
+	//	SELECT w2.word, g.count FROM two_grams g
+	//	JOIN vocab w2 ON w2.id = g.word2
+	//	WHERE g.word1 = (SELECT id FROM vocab WHERE word = 'the')
+	//	ORDER BY g.count DESC
+	NormalizeVocab bool
+
+	// TablePrefix prefixes the names of the tables of DB or DSN, such as
+	// "<prefix>_two_grams", so that they do not collide with other data. It is
+	// a lowercase SQL identifier of letters, digits and underscores.
+	TablePrefix string
+
+	// UnigramsOnly builds only the 1-grams whatever Ngrams selects, such as
+	// for a spell checker, and adds a view of them to DB or DSN:
+	//
+	//	CREATE VIEW words AS SELECT word1 AS word, count FROM one_grams
+	//
+	// whose word is a token and count its total match count, so that a
+	// dictionary is read with "SELECT word, count FROM words". The view is
+	// prefixed with TablePrefix as the tables. It requires DB or DSN and
+	// cannot be used with ShardByInitial.
+	UnigramsOnly bool
+
+	// MaxMergeEntries bounds the memory of the merge map of a file if
+	// positive: whenever the map holds that many distinct n-grams, they are
+	// inserted and the map is reset. DB then upserts, adding the counts of the
+	// n-grams found again later in the file to their rows at the cost of a
+	// unique index maintained while loading, and the exports get a row per
+	// chunk instead. MinCount applies to the count of each chunk, so that an
+	// n-gram spread over chunks may be dropped even if its total reaches
+	// MinCount. It requires DB and cannot be used with Stream.
+	MaxMergeEntries int
+
+	// ParseConcurrency is the number of downloaded files decompressed and
+	// aggregated at once, 1 if 0. Each of them holds its merge map in memory,
+	// so the peak memory of the build grows with it.
+	ParseConcurrency int
+
+	// Force builds every selected data file again, ignoring the ones
+	// recorded as built in the manifest and DB. DB is then built anew into
+	// "<DB>.tmp", which replaces it once the build is complete, so that its
+	// readers never see a partial database; an interrupted forced build is
+	// started over by the next forced one. It cannot be used with DSN or
+	// ShardByInitial, whose rows would be inserted twice.
+	Force bool
+
+	// Deterministic makes a build of the same data files with the same
+	// options yield byte-identical SQLite databases and exports. The files
+	// are always inserted in the order of their sorted urls, and Deterministic
+	// also inserts the n-grams of each file sorted by their tokens into every
+	// store and leaves the build time out of the database. A different
+	// SQLite version, resuming an interrupted build, or a SourceDir at
+	// another path, whose file urls are recorded, may still change the
+	// bytes.
+	Deterministic bool
+
+	// Progress logs the rows inserted and their rate every ProgressInterval,
+	// or every 10 seconds if it is 0.
+	Progress         bool
+	ProgressInterval time.Duration
+
+	// SafeMode uses the default SQLite journaling instead of WAL with
+	// SQLiteSynchronous, one of SynchronousModes and NORMAL if empty, and
+	// SQLiteCacheSize, the SQLite default if 0.
+	SafeMode          bool
+	SQLiteSynchronous string
+	SQLiteCacheSize   int
+}
+
+func (o BuildOptions) validate() error {
+	if err := o.Source.validate(); err != nil {
+		return err
+	}
+	if o.DB != "" && o.DSN != "" {
+		return errors.New("DB and DSN are exclusive")
+	}
+	if o.SourceDir != "" && o.Stream {
+		return errors.New("SourceDir and Stream are exclusive")
+	}
+	if o.ShardByInitial && o.DB == "" {
+		return errors.New("ShardByInitial requires DB")
+	}
+	if o.NormalizeVocab && o.DB == "" {
+		return errors.New("NormalizeVocab requires DB")
+	}
+	if o.Force && (o.DSN != "" || o.ShardByInitial) {
+		return errors.New("Force cannot be used with DSN or ShardByInitial")
+	}
+	if o.UnigramsOnly && o.DB == "" && o.DSN == "" {
+		return errors.New("UnigramsOnly requires DB or DSN")
+	}
+	if o.UnigramsOnly && o.ShardByInitial {
+		return errors.New("UnigramsOnly and ShardByInitial are exclusive")
+	}
+	if o.MaxMergeEntries > 0 && o.DB == "" {
+		return errors.New("MaxMergeEntries requires DB")
+	}
+	if o.MaxMergeEntries > 0 && o.Stream {
+		return errors.New("MaxMergeEntries and Stream are exclusive")
+	}
+	if o.TablePrefix != "" && !ValidTablePrefix(o.TablePrefix) {
+		return fmt.Errorf("invalid table prefix: %q", o.TablePrefix)
+	}
+	if o.Format != "" && !contains(Formats, o.Format) {
+		return fmt.Errorf("invalid format: %q", o.Format)
+	}
+	if o.Compress != "" && !contains(Compressions, o.Compress) {
+		return fmt.Errorf("invalid compress: %q", o.Compress)
+	}
+	if c := (exportCompression{name: o.Compress}); !validCompressLevel(c.of(o.Export), o.CompressLevel) {
+		return fmt.Errorf("invalid compress level %d of %s", o.CompressLevel, c.of(o.Export))
+	}
+	if c := (exportCompression{name: o.Compress}); o.Format == "fst" && c.of(o.Export) != "none" {
+		return fmt.Errorf("invalid compress %s of format fst", c.of(o.Export))
+	}
+	if o.SQLiteSynchronous != "" && !contains(SynchronousModes, strings.ToUpper(o.SQLiteSynchronous)) {
+		return fmt.Errorf("invalid sqlite synchronous: %q", o.SQLiteSynchronous)
+	}
+	if o.YearEnd != 0 && o.YearStart > o.YearEnd {
+		return fmt.Errorf("invalid year range: start %d is after end %d", o.YearStart, o.YearEnd)
+	}
+	return nil
+}
+
+func (o BuildOptions) buildOptions() buildOptions {
+	yearEnd := o.YearEnd
+	if yearEnd == 0 {
+		yearEnd = math.MaxInt
+	}
+
+	return buildOptions{
+		minCount:  o.MinCount,
+		yearStart: o.YearStart,
+		yearEnd:   yearEnd,
+		stripPOS:  o.StripPOS,
+		lowercase: o.Lowercase,
+
+		keepBoundaries:   o.KeepBoundaries,
+		parseConcurrency: o.ParseConcurrency,
+		deterministic:    o.Deterministic,
+		skipHeader:       o.SkipHeader,
+		maxMergeEntries:  o.MaxMergeEntries,
+	}
+}
+
+func (o BuildOptions) batchSize() int {
+	if o.BatchSize < 1 {
+		return 10000
+	}
+	return o.BatchSize
+}
+
+func (o BuildOptions) sqliteOptions() sqliteOptions {
+	synchronous := strings.ToUpper(o.SQLiteSynchronous)
+	if synchronous == "" {
+		synchronous = "NORMAL"
+	}
+
+	return sqliteOptions{
+		safeMode:    o.SafeMode,
+		synchronous: synchronous,
+		cacheSize:   o.SQLiteCacheSize,
+		batchSize:   o.batchSize(),
+
+		normalizeVocab: o.NormalizeVocab,
+		upsert:         o.MaxMergeEntries > 0,
+		deterministic:  o.Deterministic,
+		tables:         tableNames{o.TablePrefix},
+	}
+}
+
+// Build builds the databases and exports of opts from the data files of the
+// selected languages and ngrams, which are the ones of opts.SourceDir if
+// given. Files recorded as built into the same database in the manifest of
+// opts.Dir or its sources table are skipped, but an export gets every file.
+// It is a no-op if no destination is given.
+func Build(ctx context.Context, opts BuildOptions) error {
+	if err := opts.validate(); err != nil {
+		return fmt.Errorf("cannot build: %w", err)
+	}
+	if opts.UnigramsOnly {
+		opts.Ngrams = []string{"1"}
+	}
+
+	// A forced build of DB builds a new database which replaces it.
+	db := opts.DB
+	if opts.Force && db != "" {
+		opts.DB = db + ".tmp"
+		if err := removeDB(opts.DB); err != nil {
+			return fmt.Errorf("cannot build: %w", err)
+		}
+	}
+
+	store, err := openStore(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return nil
+	}
+	defer store.Close()
+
+	if opts.Stream || opts.SourceDir != "" {
+		if err := prepareOutputDir(opts.Dir); err != nil {
+			return err
+		}
+	}
+
+	m, err := loadManifest(opts.Dir, buildTarget(opts))
+	if err != nil {
+		return err
+	}
+	if opts.Force {
+		if err := m.resetBuilt(); err != nil {
+			return err
+		}
+	}
+
+	var progress *buildProgress
+	if opts.Progress {
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		progress = newBuildProgress()
+		progress.run(interval)
+		defer progress.Stop()
+	}
+
+	var ds dataset
+	var combos []combo
+	if opts.SourceDir != "" {
+		combos, err = scanSourceDir(opts.SourceDir, opts.Languages, opts.Ngrams)
+		combos = opts.limitFiles(combos)
+	} else {
+		ds = opts.dataset(ctx)
+		combos, err = opts.combos(ctx, ds)
+	}
+	if err != nil {
+		return err
+	}
+
+	// missing is the number of data files left out with SkipMissing.
+	var missing int
+	for _, c := range combos {
+		start := time.Now()
+		bo := opts.buildOptions()
+		bo.metrics = opts.Metrics.combo(c.lang, c.ngram)
+		bo.progress = progress
+
+		switch {
+		case c.dir != "":
+			if err := build(ctx, store, c.ngram, c.dir, c.urls, bo, m); err != nil {
+				return err
+			}
+		case opts.Stream:
+			if err := streamBuild(ctx, store, c.ngram, c.urls, bo, opts.downloadOptions(ds.client, opts.Dir), m); err != nil {
+				return err
+			}
+			if err := downloadTotalCounts(ctx, ds, opts.Dir, c.lang, c.ngram); err != nil {
+				return err
+			}
+		default:
+			dir, urls := comboDir(opts.Dir, ds.version, c), c.urls
+			if opts.SkipMissing {
+				urls = downloadedURLs(dir, urls)
+				missing += len(c.urls) - len(urls)
+			}
+			if err := build(ctx, store, c.ngram, dir, urls, bo, m); err != nil {
+				return err
+			}
+		}
+
+		bo.metrics.built(time.Since(start))
+	}
+
+	if idx, ok := store.(indexer); ok && !opts.SkipIndex {
+		if err := idx.createIndexes(ngramSizes(opts.Ngrams)); err != nil {
+			return err
+		}
+	}
+
+	if v, ok := store.(wordsViewer); ok && opts.UnigramsOnly {
+		if err := v.createWordsView(); err != nil {
+			return err
+		}
+	}
+
+	if o, ok := store.(optimizer); ok && opts.Optimize {
+		if err := o.optimize(); err != nil {
+			return err
+		}
+	}
+
+	if f, ok := store.(finisher); ok {
+		if err := f.finish(); err != nil {
+			return err
+		}
+	}
+	if err := store.Close(); err != nil {
+		return err
+	}
+	if opts.Force && db != "" {
+		if err := replaceDB(opts.DB, db); err != nil {
+			return fmt.Errorf("cannot build: %w", err)
+		}
+		opts.DB = db
+		if err := m.moveBuilt(buildTarget(opts)); err != nil {
+			return err
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%w: %d missing data files are not built", ErrFilesFailed, missing)
+	}
+	return nil
+}
+
+// downloadedURLs returns the urls of urls whose data files are in dir,
+// logging the others.
+func downloadedURLs(dir string, urls []string) []string {
+	var downloaded []string
+	for _, url := range urls {
+		if !fileExists(findDataFile(dir, url)) {
+			slog.Warn("skip missing data file", "url", url)
+			continue
+		}
+		downloaded = append(downloaded, url)
+	}
+	return downloaded
+}
+
+// openStore opens the stores selected by opts. It returns nil if no build is
+// requested.
+func openStore(ctx context.Context, opts BuildOptions) (Store, error) {
+	stores := &multiStore{}
+
+	switch {
+	case opts.DB != "" && opts.ShardByInitial:
+		stores.stores = append(stores.stores, newShardedStore(opts.DB, opts.sqliteOptions()))
+	case opts.DB != "":
+		s, err := newSQLiteStore(opts.DB, opts.sqliteOptions())
+		if err != nil {
+			return nil, err
+		}
+		stores.stores = append(stores.stores, s)
+	case opts.DSN != "":
+		s, err := newPostgresStore(ctx, opts.DSN, postgresOptions{
+			batchSize: opts.batchSize(),
+			retry:     opts.Retry,
+			tables:    tableNames{opts.TablePrefix},
+		})
+		if err != nil {
+			return nil, err
+		}
+		stores.stores = append(stores.stores, s)
+	}
+
+	export := opts.Export
+	if export == "" {
+		export = "-"
+	}
+
+	compression := exportCompression{name: opts.Compress, level: opts.CompressLevel}
+	switch opts.Format {
+	case "jsonl":
+		s, err := newJSONLStore(export, compression)
+		if err != nil {
+			stores.Close()
+			return nil, err
+		}
+		stores.stores = append(stores.stores, s)
+	case "csv":
+		delim := opts.CSVDelim
+		if delim == 0 {
+			delim = ','
+		}
+		s, err := newCSVStore(export, compression, delim, maxInt(ngramSizes(opts.Ngrams)))
+		if err != nil {
+			stores.Close()
+			return nil, err
+		}
+		stores.stores = append(stores.stores, s)
+	case "fst":
+		s, err := newFSTStore(export)
+		if err != nil {
+			stores.Close()
+			return nil, err
+		}
+		stores.stores = append(stores.stores, s)
+	}
+
+	switch len(stores.stores) {
+	case 0:
+		return nil, nil
+	case 1:
+		return stores.stores[0], nil
+	}
+	return stores, nil
+}
+
+// buildTarget identifies the destination of the build for the manifest. It is
+// empty if nothing is built or an export is written, so that such builds are
+// never skipped: an export is written anew from every selected file by each
+// build, while the files already in DB or DSN are skipped by their sources
+// tables.
+func buildTarget(opts BuildOptions) string {
+	if opts.Format != "" {
+		return ""
+	}
+
+	var target string
+	switch {
+	case opts.DB != "":
+		abs, err := filepath.Abs(opts.DB)
+		if err != nil {
+			abs = opts.DB
+		}
+		target = "sqlite:" + abs
+		if opts.ShardByInitial {
+			target = "sqlite-shards:" + abs
+		}
+	case opts.DSN != "":
+		// The DSN may contain a password, so only its hash is recorded.
+		sum := sha256.Sum256([]byte(opts.DSN))
+		target = "postgres:" + hex.EncodeToString(sum[:8])
+	default:
+		return ""
+	}
+
+	// The tables of another prefix are another destination in the same
+	// database.
+	if opts.TablePrefix != "" {
+		target += "#" + opts.TablePrefix
+	}
+	return target
+}
+
+// ngramSizes converts the verified ngram elements into numbers.
+func ngramSizes(ngrams []string) []int {
+	sizes := make([]int, 0, len(ngrams))
+	for _, ngram := range ngrams {
+		n, _ := strconv.Atoi(ngram)
+		sizes = append(sizes, n)
+	}
+	return sizes
+}
+
+func maxInt(xs []int) int {
+	max := 0
+	for _, x := range xs {
+		if x > max {
+			max = x
+		}
+	}
+	return max
+}
+
+func contains(xs []string, x string) bool {
+	for _, y := range xs {
+		if x == y {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package mocword
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// rename is os.Rename, which tests replace to fail across filesystems.
+var rename = os.Rename
+
+// moveFile renames src to dst. If they are on different filesystems, src is
+// copied into a temporary file next to dst which is then renamed, so that dst
+// appears atomically, and src is removed.
+func moveFile(src, dst string) error {
+	err := rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return fmt.Errorf("cannot move %s to %s: %w", src, dst, err)
+	}
+	return os.Remove(src)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	if _, err := io.Copy(tmpfile, in); err != nil {
+		return err
+	}
+	if err := tmpfile.Sync(); err != nil {
+		return err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpfile.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpfile.Name(), dst)
+}
+
+// decompressFile writes the file src compressed by d decompressed to dst
+// atomically and removes src.
+func decompressFile(src, dst string, d decompressor) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+	defer in.Close()
+
+	r, err := d.newReader(bufio.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+	defer r.Close()
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst))
+	if err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	if _, err := io.Copy(tmpfile, r); err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+	if err := tmpfile.Sync(); err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+	if err := os.Chmod(tmpfile.Name(), 0644); err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+	if err := os.Rename(tmpfile.Name(), dst); err != nil {
+		return fmt.Errorf("cannot decompress %s: %w", src, err)
+	}
+
+	in.Close()
+	return os.Remove(src)
+}
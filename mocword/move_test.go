@@ -0,0 +1,52 @@
+package mocword
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// renameAcrossDevices makes moveFile fail to rename as if its files were on
+// different filesystems until the test ends.
+func renameAcrossDevices(t *testing.T) {
+	rename = func(src, dst string) error {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: syscall.EXDEV}
+	}
+	t.Cleanup(func() { rename = os.Rename })
+}
+
+func TestMoveFileAcrossDevices(t *testing.T) {
+	renameAcrossDevices(t)
+
+	src := filepath.Join(t.TempDir(), "src")
+	dstDir := t.TempDir()
+	dst := filepath.Join(dstDir, "dst")
+	if err := os.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := os.ReadFile(dst); err != nil || string(got) != "data" {
+		t.Errorf("dst = %q, %v, want %q", got, err, "data")
+	}
+	if _, err := os.Stat(src); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("src still exists: %v", err)
+	}
+	if entries, err := os.ReadDir(dstDir); err != nil || len(entries) != 1 {
+		t.Errorf("dst dir holds %v, %v, want only dst", entries, err)
+	}
+}
+
+func TestMoveFileError(t *testing.T) {
+	dir := t.TempDir()
+	err := moveFile(filepath.Join(dir, "missing"), filepath.Join(dir, "dst"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("moveFile() of a missing file = %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,236 @@
+package mocword
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Ngram is a record of a downloaded ngram file.
+type Ngram struct {
+	Tokens []string
+	Counts []NgramCount
+}
+
+// NgramCount is a per-year entry of an Ngram.
+type NgramCount struct {
+	Year        int
+	MatchCount  int64
+	VolumeCount int64
+}
+
+// errAmbiguousNgramLine marks an ngram line whose tokens cannot be told from
+// its year entries, which NgramScanner skips.
+var errAmbiguousNgramLine = errors.New("ambiguous ngram line")
+
+// ParseNgramLine parses a line such as "word1 word2\t2008,50,12\t2009,3,1".
+//
+// The tokens are split on the ASCII spaces between them only, as the dataset
+// specifies, and their bytes are kept as is. Multibyte UTF-8 tokens, such as
+// the ones of chi_sim, and other Unicode spaces within a token are intact.
+//
+// The year entries are the trailing fields of the YYYY,int,int shape, and the
+// fields before them are the tokens, so that a stray tab within the tokens is
+// kept in them with a warning. A line is ambiguous if one of the fields of
+// its tokens after the first has the shape of a year entry too.
+func ParseNgramLine(line string) (Ngram, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 2 {
+		return Ngram{}, fmt.Errorf("invalid ngram line: no year entries: %q", line)
+	}
+	if fields[0] == "" {
+		return Ngram{}, fmt.Errorf("invalid ngram line: no tokens: %q", line)
+	}
+
+	counts := make([]NgramCount, 0, len(fields)-1)
+	i := len(fields)
+	for ; i > 1; i-- {
+		c, err := parseNgramCount(fields[i-1])
+		if err != nil {
+			if i == len(fields) {
+				return Ngram{}, fmt.Errorf("invalid ngram line: %w: %q", err, line)
+			}
+			break
+		}
+		counts = append(counts, c)
+	}
+	slices.Reverse(counts)
+
+	if i > 1 {
+		for _, field := range fields[1:i] {
+			if _, err := parseNgramCount(field); err == nil {
+				return Ngram{}, fmt.Errorf("%w: year entry %q within the tokens: %q", errAmbiguousNgramLine, field, line)
+			}
+		}
+		slog.Warn("ngram tokens contain a tab", "line", line)
+	}
+
+	return Ngram{
+		Tokens: strings.Split(strings.Join(fields[:i], "\t"), " "),
+		Counts: counts,
+	}, nil
+}
+
+// parseNgramCount parses a year entry of the YYYY,int,int shape, such as
+// "2008,50,12". A field of another shape, such as "1,2,3", is not one.
+func parseNgramCount(field string) (c NgramCount, err error) {
+	elems := strings.Split(field, ",")
+	if len(elems) != 3 || len(elems[0]) != 4 || !isDigits(elems[0]) || !isDigits(elems[1]) || !isDigits(elems[2]) {
+		err = fmt.Errorf("invalid year entry %q", field)
+		return
+	}
+
+	if c.Year, err = strconv.Atoi(elems[0]); err != nil {
+		err = fmt.Errorf("invalid year in %q", field)
+		return
+	}
+	if c.MatchCount, err = strconv.ParseInt(elems[1], 10, 64); err != nil {
+		err = fmt.Errorf("invalid match count in %q", field)
+		return
+	}
+	if c.VolumeCount, err = strconv.ParseInt(elems[2], 10, 64); err != nil {
+		err = fmt.Errorf("invalid volume count in %q", field)
+		return
+	}
+
+	return
+}
+
+// isDigits reports whether s is a non-empty run of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// posTags are the part-of-speech tags of Google Books Ngram tokens.
+var posTags = map[string]bool{
+	"NOUN": true, "VERB": true, "ADJ": true, "ADV": true, "PRON": true, "DET": true,
+	"ADP": true, "NUM": true, "CONJ": true, "PRT": true, "X": true, ".": true,
+}
+
+// stripPOS removes part-of-speech suffixes such as "book_NOUN" -> "book" and
+// drops tokens which are a tag by themselves such as "_NOUN_".
+func stripPOS(tokens []string) []string {
+	stripped := make([]string, 0, len(tokens))
+
+	for _, token := range tokens {
+		if strings.HasPrefix(token, "_") && strings.HasSuffix(token, "_") && posTags[strings.Trim(token, "_")] {
+			continue
+		}
+
+		if i := strings.LastIndex(token, "_"); i > 0 && posTags[token[i+1:]] {
+			token = token[:i]
+		}
+		stripped = append(stripped, token)
+	}
+
+	return stripped
+}
+
+// boundaryTokens are the tokens of Google Books Ngram marking the start and
+// the end of a sentence.
+var boundaryTokens = map[string]bool{"_START_": true, "_END_": true}
+
+// stripBoundaries drops the sentence boundary tokens such as "_START_" from
+// tokens.
+func stripBoundaries(tokens []string) []string {
+	stripped := make([]string, 0, len(tokens))
+
+	for _, token := range tokens {
+		if !boundaryTokens[token] {
+			stripped = append(stripped, token)
+		}
+	}
+
+	return stripped
+}
+
+// errNgramRead marks a failure to read the underlying reader, as opposed to a
+// malformed line.
+var errNgramRead = errors.New("cannot read ngram file")
+
+const maxNgramLineSize = 16 * 1024 * 1024
+
+// NgramScanner reads Ngrams line by line from a decompressed ngram file.
+// Blank lines, ambiguous lines and a leading UTF-8 byte order mark are
+// skipped.
+type NgramScanner struct {
+	// SkipHeader skips the first line if it is not an n-gram record, such as
+	// the header row of a re-exported file, instead of failing.
+	SkipHeader bool
+
+	sc    *bufio.Scanner
+	line  int
+	ngram Ngram
+	err   error
+}
+
+// NewNgramScanner returns a NgramScanner reading from r, typically a *gzip.Reader.
+func NewNgramScanner(r io.Reader) *NgramScanner {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxNgramLineSize)
+	return &NgramScanner{sc: sc}
+}
+
+// Scan advances to the next Ngram. It returns false at the end of the input
+// or on the first error.
+func (s *NgramScanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+
+	for s.sc.Scan() {
+		s.line++
+
+		line := s.sc.Text()
+		if s.line == 1 {
+			line = strings.TrimPrefix(line, "\uFEFF")
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		ngram, err := ParseNgramLine(line)
+		if err != nil && s.line == 1 && s.SkipHeader {
+			slog.Debug("skip header line", "line", line, "error", err)
+			continue
+		}
+		if errors.Is(err, errAmbiguousNgramLine) {
+			slog.Warn("skip ambiguous ngram line", "line", s.line, "error", err)
+			continue
+		}
+		if err != nil {
+			s.err = fmt.Errorf("line %d: %w", s.line, err)
+			return false
+		}
+		s.ngram = ngram
+		return true
+	}
+
+	if err := s.sc.Err(); err != nil {
+		s.err = fmt.Errorf("line %d: %w: %w", s.line+1, errNgramRead, err)
+	}
+	return false
+}
+
+// Ngram returns the Ngram read by the last Scan.
+func (s *NgramScanner) Ngram() Ngram {
+	return s.ngram
+}
+
+// Err returns the first error encountered by Scan.
+func (s *NgramScanner) Err() error {
+	return s.err
+}
@@ -0,0 +1,99 @@
+package mocword
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStripPOS(t *testing.T) {
+	tests := []struct {
+		tokens string
+		want   string
+	}{
+		{"book_NOUN", "book"},
+		{"read_VERB the_DET book_NOUN", "read the book"},
+		{"_NOUN_", ""},
+		{"the _ADJ_ book", "the book"},
+		{"book", "book"},
+		{"snake_case", "snake_case"},
+		{"_START_ book", "_START_ book"},
+		{"._.", "."},
+	}
+
+	for _, tt := range tests {
+		if got := strings.Join(stripPOS(strings.Fields(tt.tokens)), " "); got != tt.want {
+			t.Errorf("stripPOS(%q) = %q, want %q", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestParseNgramLineMultibyte(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"中国 人民\t2000,3,1", []string{"中国", "人民"}},
+		{"我们_PRON 的_PRT 生活_NOUN\t2000,3,1\t2001,4,2", []string{"我们_PRON", "的_PRT", "生活_NOUN"}},
+		{"你好　世界 。\t2000,3,1", []string{"你好　世界", "。"}},
+	}
+
+	for _, tt := range tests {
+		ngram, err := ParseNgramLine(tt.line)
+		if err != nil {
+			t.Errorf("ParseNgramLine(%q): %v", tt.line, err)
+			continue
+		}
+		if strings.Join(ngram.Tokens, "\x00") != strings.Join(tt.want, "\x00") {
+			t.Errorf("ParseNgramLine(%q) tokens = %q, want %q", tt.line, ngram.Tokens, tt.want)
+		}
+	}
+}
+
+func TestParseNgramLineFields(t *testing.T) {
+	tests := []struct {
+		line      string
+		tokens    []string
+		counts    int
+		ambiguous bool
+		invalid   bool
+	}{
+		{line: "book\t2000,3,1\t2001,4,2", tokens: []string{"book"}, counts: 2},
+		{line: "1,2,3\t2000,3,1", tokens: []string{"1,2,3"}, counts: 1},
+		{line: "2000,3,1 book\t2000,3,1", tokens: []string{"2000,3,1", "book"}, counts: 1},
+		{line: "book\t1,2,3\t2000,3,1", tokens: []string{"book\t1,2,3"}, counts: 1},
+		{line: "the\tbook\t2000,3,1", tokens: []string{"the\tbook"}, counts: 1},
+		{line: "the\t1,2,3\tbook\t2000,3,1", tokens: []string{"the\t1,2,3\tbook"}, counts: 1},
+		{line: "the\t1999,2,3\tbook\t2000,3,1", ambiguous: true},
+		{line: "book\t2000,3", invalid: true},
+		{line: "book\t200,3,1", invalid: true},
+		{line: "book\t2000,-3,1", invalid: true},
+		{line: "book", invalid: true},
+		{line: "\t2000,3,1", invalid: true},
+	}
+
+	for _, tt := range tests {
+		ngram, err := ParseNgramLine(tt.line)
+		switch {
+		case tt.ambiguous:
+			if !errors.Is(err, errAmbiguousNgramLine) {
+				t.Errorf("ParseNgramLine(%q) error = %v, want an ambiguous line", tt.line, err)
+			}
+			continue
+		case tt.invalid:
+			if err == nil || errors.Is(err, errAmbiguousNgramLine) {
+				t.Errorf("ParseNgramLine(%q) error = %v, want an invalid line", tt.line, err)
+			}
+			continue
+		case err != nil:
+			t.Errorf("ParseNgramLine(%q): %v", tt.line, err)
+			continue
+		}
+		if strings.Join(ngram.Tokens, "\x00") != strings.Join(tt.tokens, "\x00") {
+			t.Errorf("ParseNgramLine(%q) tokens = %q, want %q", tt.line, ngram.Tokens, tt.tokens)
+		}
+		if len(ngram.Counts) != tt.counts {
+			t.Errorf("ParseNgramLine(%q) has %d year entries, want %d", tt.line, len(ngram.Counts), tt.counts)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package mocword
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// postgresOptions configures the PostgreSQL store.
+type postgresOptions struct {
+	// batchSize is the number of rows sent per COPY.
+	batchSize int
+
+	// retry tells the reconnections tried on a transient error.
+	retry RetryPolicy
+
+	// tables names the tables of the database.
+	tables tableNames
+}
+
+// postgresStore is a Store writing n-grams into PostgreSQL with COPY FROM
+// STDIN, one table per n-gram size. Rows are buffered and copied every
+// batchSize rows. The copies of a batch are committed in one transaction
+// together with the number of rows loaded from the current source, so a batch
+// which fails on a broken connection is copied again after reconnecting, and
+// an interrupted build resumes after the last committed batch.
+type postgresStore struct {
+	dsn    string
+	opts   postgresOptions
+	conn   *pgx.Conn
+	tables map[int]bool
+
+	rows   map[int][][]interface{}
+	buffed int
+	closed bool
+
+	// source is the url whose rows are inserted, and sourceRows the number of
+	// its rows inserted including the ones of previous builds.
+	source     string
+	sourceRows int64
+}
+
+func newPostgresStore(ctx context.Context, dsn string, opts postgresOptions) (*postgresStore, error) {
+	if opts.batchSize < 1 {
+		opts.batchSize = 1
+	}
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to postgres: %w", err)
+	}
+
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (url TEXT PRIMARY KEY, rows BIGINT NOT NULL, completed_at TEXT)", opts.tables.sources())
+	if _, err := conn.Exec(ctx, query); err != nil {
+		conn.Close(ctx)
+		return nil, fmt.Errorf("cannot create table %s: %w", opts.tables.sources(), err)
+	}
+
+	return &postgresStore{
+		dsn:    dsn,
+		opts:   opts,
+		conn:   conn,
+		tables: make(map[int]bool),
+		rows:   make(map[int][][]interface{}),
+	}, nil
+}
+
+func (s *postgresStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	n := len(tokens)
+	if _, err := ngramTableName(n); err != nil {
+		return fmt.Errorf("cannot insert: %w", err)
+	}
+
+	s.rows[n] = append(s.rows[n], ngramArgs(tokens, count))
+	s.buffed++
+	s.sourceRows++
+
+	if s.buffed >= s.opts.batchSize {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush copies the buffered rows into their tables in one transaction.
+func (s *postgresStore) Flush(ctx context.Context) error {
+	if s.buffed == 0 {
+		return ctx.Err()
+	}
+
+	if err := s.withReconnect(ctx, func() error { return s.commitRows(ctx) }); err != nil {
+		return err
+	}
+	clear(s.rows)
+	s.buffed = 0
+
+	return ctx.Err()
+}
+
+// commitRows copies the buffered rows and records the rows committed from
+// the current source in a transaction.
+func (s *postgresStore) commitRows(ctx context.Context) error {
+	for n := range s.rows {
+		if err := s.ensureTable(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for n, rows := range s.rows {
+		if err := copyRows(ctx, tx, s.opts.tables, n, rows); err != nil {
+			return err
+		}
+	}
+
+	if s.source != "" {
+		_, err := tx.Exec(ctx,
+			"INSERT INTO "+s.opts.tables.sources()+" (url, rows) VALUES ($1, $2) ON CONFLICT (url) DO UPDATE SET rows = excluded.rows",
+			s.source, s.sourceRows,
+		)
+		if err != nil {
+			return fmt.Errorf("cannot update sources: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("cannot commit: %w", err)
+	}
+	return nil
+}
+
+func copyRows(ctx context.Context, tx pgx.Tx, t tableNames, n int, rows [][]interface{}) error {
+	table, _ := t.ngram(n)
+	cols := make([]string, 0, n+1)
+	for i := 1; i <= n; i++ {
+		cols = append(cols, fmt.Sprintf("word%d", i))
+	}
+	cols = append(cols, "count")
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{table}, cols, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("cannot copy into %s: %w", table, err)
+	}
+	return nil
+}
+
+// Close closes the connection. Buffered rows which are not flushed are lost.
+// Closing twice is a no-op.
+func (s *postgresStore) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.conn.Close(ctx)
+}
+
+func (s *postgresStore) sourceCompleted(ctx context.Context, url string) (bool, error) {
+	var completedAt *string
+	err := s.conn.QueryRow(ctx, "SELECT completed_at FROM "+s.opts.tables.sources()+" WHERE url = $1", url).Scan(&completedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot query sources: %w", err)
+	}
+	return completedAt != nil, nil
+}
+
+func (s *postgresStore) startSource(ctx context.Context, url string) (int64, error) {
+	var rows int64
+	err := s.conn.QueryRow(ctx, "SELECT rows FROM "+s.opts.tables.sources()+" WHERE url = $1", url).Scan(&rows)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("cannot query sources: %w", err)
+	}
+
+	s.source, s.sourceRows = url, rows
+	return rows, nil
+}
+
+func (s *postgresStore) completeSource(ctx context.Context, url string) error {
+	_, err := s.conn.Exec(ctx,
+		"INSERT INTO "+s.opts.tables.sources()+" (url, rows, completed_at) VALUES ($1, $2, $3) ON CONFLICT (url) DO UPDATE SET rows = excluded.rows, completed_at = excluded.completed_at",
+		url, s.sourceRows, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot update sources: %w", err)
+	}
+
+	s.source, s.sourceRows = "", 0
+	return nil
+}
+
+// createIndexes creates the indexes for prefix lookups of the tables of
+// ngrams. It is meant to be called after bulk loading.
+func (s *postgresStore) createIndexes(ngrams []int) error {
+	ctx := context.Background()
+
+	for _, n := range ngrams {
+		if err := s.ensureTable(ctx, n); err != nil {
+			return err
+		}
+
+		table, _ := s.opts.tables.ngram(n)
+		prefixLen := n - 1
+		if prefixLen < 1 {
+			prefixLen = 1
+		}
+		var cols []string
+		for i := 1; i <= prefixLen; i++ {
+			cols = append(cols, fmt.Sprintf("word%d", i))
+		}
+
+		query := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_prefix_idx ON %s (%s)", table, table, strings.Join(cols, ", "))
+		if _, err := s.conn.Exec(ctx, query); err != nil {
+			return fmt.Errorf("cannot create index on %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// createWordsView creates the words view of the 1-grams, creating their table
+// if missing.
+func (s *postgresStore) createWordsView() error {
+	ctx := context.Background()
+
+	if err := s.ensureTable(ctx, 1); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("CREATE OR REPLACE VIEW %s AS %s", s.opts.tables.words(), wordsViewQuery(s.opts.tables, false))
+	if _, err := s.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("cannot create view %s: %w", s.opts.tables.words(), err)
+	}
+	return nil
+}
+
+func (s *postgresStore) ensureTable(ctx context.Context, n int) error {
+	if s.tables[n] {
+		return nil
+	}
+
+	table, err := s.opts.tables.ngram(n)
+	if err != nil {
+		return fmt.Errorf("cannot create table: %w", err)
+	}
+
+	var cols []string
+	for i := 1; i <= n; i++ {
+		cols = append(cols, fmt.Sprintf("word%d TEXT NOT NULL", i))
+	}
+	cols = append(cols, "count BIGINT NOT NULL")
+
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(cols, ", "))
+	if _, err := s.conn.Exec(ctx, query); err != nil {
+		return fmt.Errorf("cannot create table %s: %w", table, err)
+	}
+
+	s.tables[n] = true
+	return nil
+}
+
+// withReconnect runs f, reconnecting and running it again on transient
+// connection errors.
+func (s *postgresStore) withReconnect(ctx context.Context, f func() error) error {
+	var reconnect bool
+	return s.opts.retry.retry(ctx, func() error {
+		if reconnect {
+			conn, err := pgx.Connect(ctx, s.dsn)
+			if err != nil {
+				slog.Warn("cannot reconnect to postgres", "error", err)
+				return err
+			}
+			s.conn.Close(ctx)
+			s.conn = conn
+			reconnect = false
+		}
+
+		err := f()
+		if err == nil {
+			return nil
+		}
+		if !(isTransientPostgresError(err) || s.conn.IsClosed()) {
+			return Permanent(err)
+		}
+		reconnect = true
+		return err
+	}, func(attempt int, wait time.Duration, err error) {
+		slog.Warn("reconnect to postgres", "attempt", attempt, "max_attempts", s.opts.retry.MaxAttempts, "wait", wait, "error", err)
+	})
+}
+
+func isTransientPostgresError(err error) bool {
+	if pgconn.SafeToRetry(err) || pgconn.Timeout(err) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// Connection exceptions and operator intervention such as a server
+	// restart.
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || strings.HasPrefix(pgErr.Code, "57P")
+	}
+
+	return false
+}
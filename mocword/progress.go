@@ -0,0 +1,305 @@
+package mocword
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progress tracks the aggregate progress of the files downloaded or verified
+// by a run. It is safe for concurrent use, and a nil *progress reports
+// nothing.
+type progress struct {
+	totalFiles int64
+	totalBytes int64
+	doneFiles  atomic.Int64
+	doneBytes  atomic.Int64
+	failed     atomic.Int64
+
+	start time.Time
+	tty   bool
+	out   io.Writer
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newProgress returns a progress of totalFiles files of totalBytes bytes in
+// total. totalBytes may be 0 if the sizes are unknown.
+func newProgress(totalFiles int, totalBytes int64) *progress {
+	return &progress{
+		totalFiles: int64(totalFiles),
+		totalBytes: totalBytes,
+		start:      time.Now(),
+		tty:        isTerminal(os.Stderr),
+		out:        os.Stderr,
+		stop:       make(chan struct{}),
+	}
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// run starts reporting in the background until Stop is called. A terminal
+// gets a progress bar redrawn every second, otherwise a log line is written
+// every interval.
+func (p *progress) run(interval time.Duration) {
+	if p == nil {
+		return
+	}
+	if p.tty {
+		interval = time.Second
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				p.report()
+				if p.tty {
+					fmt.Fprintln(p.out)
+				}
+				return
+			case <-ticker.C:
+				p.report()
+			}
+		}
+	}()
+}
+
+// Stop stops reporting after a final report.
+func (p *progress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// reader returns r counting the bytes read from it.
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, p: p}
+}
+
+// fileDone records a completed file.
+func (p *progress) fileDone() {
+	if p == nil {
+		return
+	}
+	p.doneFiles.Add(1)
+}
+
+// fileFailed records a file which could not be downloaded.
+func (p *progress) fileFailed() {
+	if p == nil {
+		return
+	}
+	p.failed.Add(1)
+}
+
+func (p *progress) report() {
+	if p.tty {
+		fmt.Fprintf(p.out, "\r\033[K%s", p.String())
+		return
+	}
+
+	attrs := []any{
+		"files", p.doneFiles.Load(),
+		"total_files", p.totalFiles,
+		"bytes", p.doneBytes.Load(),
+		"total_bytes", p.totalBytes,
+	}
+	if eta, ok := p.eta(); ok {
+		attrs = append(attrs, "eta", eta)
+	}
+	slog.Info("progress", attrs...)
+}
+
+// eta estimates the time left from the average rate so far.
+func (p *progress) eta() (time.Duration, bool) {
+	doneBytes := p.doneBytes.Load()
+	elapsed := time.Since(p.start)
+	rest := p.totalBytes - doneBytes
+
+	if elapsed < time.Second || doneBytes == 0 || p.totalBytes <= 0 || rest <= 0 {
+		return 0, false
+	}
+	rate := float64(doneBytes) / elapsed.Seconds()
+	return time.Duration(float64(rest) / rate * float64(time.Second)).Round(time.Second), true
+}
+
+// String formats the current progress such as
+// "12/200 files, 1.2 GiB/20.0 GiB (6.0%), 3.4 MiB/s, ETA 1h34m".
+func (p *progress) String() string {
+	doneFiles := p.doneFiles.Load()
+	doneBytes := p.doneBytes.Load()
+	elapsed := time.Since(p.start)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d/%d files, %s", doneFiles, p.totalFiles, formatBytes(doneBytes))
+	if p.totalBytes > 0 {
+		ratio := float64(doneBytes) / float64(p.totalBytes)
+		if ratio > 1 {
+			ratio = 1
+		}
+		fmt.Fprintf(&b, "/%s (%.1f%%)", formatBytes(p.totalBytes), 100*ratio)
+	}
+
+	if elapsed < time.Second || doneBytes == 0 {
+		return b.String()
+	}
+
+	rate := float64(doneBytes) / elapsed.Seconds()
+	fmt.Fprintf(&b, ", %s/s", formatBytes(int64(rate)))
+
+	if eta, ok := p.eta(); ok {
+		fmt.Fprintf(&b, ", ETA %v", eta)
+	}
+	return b.String()
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.doneBytes.Add(int64(n))
+	return n, err
+}
+
+// logSummary logs the downloads of a Download which took elapsed and ended
+// with err, at the info level on success and as a warning otherwise. p may be
+// nil if nothing was downloaded.
+func logSummary(ctx context.Context, p *progress, elapsed time.Duration, err error) {
+	attrs := []any{"duration", elapsed.Round(time.Second)}
+	if p != nil {
+		bytes := p.doneBytes.Load()
+		attrs = append(attrs, "files", p.doneFiles.Load(), "failed", p.failed.Load(), "bytes", bytes)
+		if secs := elapsed.Seconds(); secs > 0 {
+			attrs = append(attrs, "throughput", formatBytes(int64(float64(bytes)/secs))+"/s")
+		}
+	}
+
+	switch {
+	case err != nil && ctx.Err() == context.DeadlineExceeded:
+		slog.Warn("download stopped early at the deadline", attrs...)
+	case err != nil:
+		slog.Warn("download aborted", attrs...)
+	default:
+		slog.Info("download finished", attrs...)
+	}
+}
+
+// buildProgress counts the rows inserted and the bytes parsed by a Build,
+// which are updated with atomics by every parsing and inserting goroutine.
+// A nil *buildProgress counts nothing.
+type buildProgress struct {
+	rows  atomic.Int64
+	bytes atomic.Int64
+
+	start time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newBuildProgress() *buildProgress {
+	return &buildProgress{start: time.Now(), stop: make(chan struct{})}
+}
+
+// run logs the progress every interval in the background until Stop is
+// called, with the rate of the rows inserted since the previous log.
+func (p *buildProgress) run(interval time.Duration) {
+	if p == nil {
+		return
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastRows, last := int64(0), p.start
+		for {
+			select {
+			case <-p.stop:
+				p.report(lastRows, last)
+				return
+			case <-ticker.C:
+				lastRows, last = p.report(lastRows, last)
+			}
+		}
+	}()
+}
+
+// Stop stops logging after a final log.
+func (p *buildProgress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	p.wg.Wait()
+}
+
+// report logs the progress and returns the rows and time it was logged at.
+func (p *buildProgress) report(lastRows int64, last time.Time) (int64, time.Time) {
+	rows, now := p.rows.Load(), time.Now()
+
+	var rate float64
+	if secs := now.Sub(last).Seconds(); secs > 0 {
+		rate = float64(rows-lastRows) / secs
+	}
+	slog.Info("build progress", "rows", rows, "rows_per_sec", int64(rate), "bytes_parsed", p.bytes.Load(), "elapsed", now.Sub(p.start).Round(time.Second))
+	return rows, now
+}
+
+func (p *buildProgress) rowInserted() {
+	if p == nil {
+		return
+	}
+	p.rows.Add(1)
+}
+
+// reader returns r counting the bytes read from it as parsed.
+func (p *buildProgress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &buildProgressReader{r: r, p: p}
+}
+
+type buildProgressReader struct {
+	r io.Reader
+	p *buildProgress
+}
+
+func (r *buildProgressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.bytes.Add(int64(n))
+	return n, err
+}
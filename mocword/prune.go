@@ -0,0 +1,90 @@
+package mocword
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// PruneOptions configures Prune.
+type PruneOptions struct {
+	// DB is the SQLite database built by Build to prune.
+	DB string
+
+	// MinCount deletes the n-grams whose count is below it.
+	MinCount int64
+
+	// TablePrefix is the BuildOptions.TablePrefix of the tables of DB.
+	TablePrefix string
+}
+
+// Prune deletes the n-grams of opts.DB whose count is below opts.MinCount
+// table by table, and vacuums the database to reclaim their space. The
+// words left unused in a vocab table are kept.
+func Prune(ctx context.Context, opts PruneOptions) error {
+	if opts.DB == "" {
+		return errors.New("cannot prune: no db")
+	}
+	if _, err := os.Stat(opts.DB); err != nil {
+		return fmt.Errorf("cannot prune: %w", err)
+	}
+	if opts.TablePrefix != "" && !ValidTablePrefix(opts.TablePrefix) {
+		return fmt.Errorf("cannot prune: invalid table prefix: %q", opts.TablePrefix)
+	}
+	t := tableNames{opts.TablePrefix}
+
+	db, err := openDB(opts.DB, sqliteOptions{synchronous: "NORMAL"})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if err := checkpointDB(db); err != nil {
+		return err
+	}
+	before, err := fileSize(opts.DB)
+	if err != nil {
+		return fmt.Errorf("cannot prune: %w", err)
+	}
+
+	var removed int64
+	for n := 1; n <= len(ngramTableNames); n++ {
+		table, _ := t.ngram(n)
+		exists, err := tableExists(db, table)
+		if err != nil {
+			return fmt.Errorf("cannot prune %s: %w", table, err)
+		}
+		if !exists {
+			continue
+		}
+
+		res, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE count < ?", opts.MinCount)
+		if err != nil {
+			return fmt.Errorf("cannot prune %s: %w", table, err)
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("cannot prune %s: %w", table, err)
+		}
+		kept, err := countRows(db, t, n)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("pruned", "table", table, "removed", rows, "kept", kept)
+		removed += rows
+	}
+
+	if err := optimizeDB(db, opts.DB); err != nil {
+		return err
+	}
+	after, err := fileSize(opts.DB)
+	if err != nil {
+		return fmt.Errorf("cannot prune: %w", err)
+	}
+
+	slog.Info("pruned db", "file", opts.DB, "removed", removed, "bytes_reclaimed", before-after)
+	return db.Close()
+}
@@ -0,0 +1,18 @@
+package mocword
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestPruneTablePrefix(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "ngrams.db")
+	writeTestDB(t, db, sqliteOptions{tables: tableNames{"p"}}, map[string]int64{"apple": 1, "banana": 5})
+
+	if err := Prune(context.Background(), PruneOptions{DB: db, MinCount: 2, TablePrefix: "p"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertCounts(t, db, readTableCounts(t, db, "p_one_grams"), map[string]int64{"banana": 5})
+}
@@ -0,0 +1,130 @@
+package mocword
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Statuses of a FileReport.
+const (
+	FileDownloaded = "downloaded"
+	FileSkipped    = "skipped"
+	FileFailed     = "failed"
+)
+
+// Report is a machine-readable record of a run. Download fills it in if
+// given as DownloadOptions.Report, and the caller finishes it with Finish
+// and writes it with Save. It is safe for concurrent use.
+type Report struct {
+	Time           time.Time    `json:"time"`
+	Version        string       `json:"version"`
+	DatasetVersion string       `json:"dataset_version"`
+	Languages      []string     `json:"languages"`
+	Ngrams         []string     `json:"ngrams"`
+	Files          []FileReport `json:"files"`
+	Success        bool         `json:"success"`
+	Error          string       `json:"error,omitempty"`
+
+	mu sync.Mutex
+}
+
+// FileReport is the outcome of a data file in a Report.
+type FileReport struct {
+	URL      string  `json:"url"`
+	Status   string  `json:"status"`
+	Bytes    int64   `json:"bytes"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// start records the selection of a run. A nil *Report records nothing.
+func (r *Report) start(version string, langs, ngrams []string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.DatasetVersion = version
+	r.Languages = langs
+	r.Ngrams = ngrams
+}
+
+// file records the outcome of url, which took d. bytes is the size of fname
+// if it exists.
+func (r *Report) file(url, status, fname string, d time.Duration, err error) {
+	if r == nil {
+		return
+	}
+
+	fr := FileReport{URL: url, Status: status, Duration: d.Seconds()}
+	if fi, serr := os.Stat(fname); serr == nil {
+		fr.Bytes = fi.Size()
+	}
+	if err != nil {
+		fr.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Files = append(r.Files, fr)
+}
+
+// Finish records the result of the run, which succeeded if err is nil.
+func (r *Report) Finish(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Version = ReadBuildInfo().String()
+	r.Success = err == nil
+	r.Error = ""
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// Save writes r as JSON to fname atomically.
+func (r *Report) Save(fname string) error {
+	r.mu.Lock()
+	if r.Files == nil {
+		r.Files = []FileReport{}
+	}
+	buf, err := json.MarshalIndent(r, "", "\t")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot save report: %w", err)
+	}
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname))
+	if err != nil {
+		return fmt.Errorf("cannot save report: %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	if _, err := tmpfile.Write(append(buf, '\n')); err != nil {
+		return fmt.Errorf("cannot save report: %w", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		return fmt.Errorf("cannot save report: %w", err)
+	}
+	if err := os.Chmod(tmpfile.Name(), 0644); err != nil {
+		return fmt.Errorf("cannot save report: %w", err)
+	}
+
+	if err := os.Rename(tmpfile.Name(), fname); err != nil {
+		return fmt.Errorf("cannot save report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package mocword
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy tells how a failing operation is tried again: the delay before
+// the attempt after the nth failure is BaseDelay*2^(n-1), up to MaxDelay, of
+// which a random fraction up to Jitter is taken off.
+type RetryPolicy struct {
+	// BaseDelay is the delay before the first retry, 1 second if 0.
+	BaseDelay time.Duration
+
+	// MaxDelay is the max delay before a retry, 30 seconds if 0.
+	MaxDelay time.Duration
+
+	// MaxAttempts is the max number of attempts including the first one.
+	// The operation is not retried if it is less than 2.
+	MaxAttempts int
+
+	// Jitter is the fraction from 0 to 1 of each delay which is randomized so
+	// that many failing clients do not retry at once.
+	Jitter float64
+
+	// MaxRetryAfter caps the delay a failure asks for, such as with the
+	// Retry-After header of a rate-limited response, which replaces the
+	// backoff. It is 5 minutes if 0.
+	MaxRetryAfter time.Duration
+
+	// after and random replace time.After and rand.Float64 if not nil.
+	after  func(time.Duration) <-chan time.Time
+	random func() float64
+}
+
+// permanentError marks a failure which is not retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that RetryPolicy.Do returns it without retrying.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// retryAfterer is implemented by the errors of the failures which tell the
+// delay before they may be tried again, such as by a Retry-After header.
+type retryAfterer interface {
+	retryAfter() time.Duration
+}
+
+// Do runs f until it succeeds, returns a Permanent error, ctx is done or
+// p.MaxAttempts are made, and returns the last error of f with its Permanent
+// mark removed.
+func (p RetryPolicy) Do(ctx context.Context, f func() error) error {
+	return p.retry(ctx, f, nil)
+}
+
+// retry is Do calling onRetry with the number of the next attempt, the delay
+// before it and the error of the failed one.
+func (p RetryPolicy) retry(ctx context.Context, f func() error, onRetry func(attempt int, wait time.Duration, err error)) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return nil
+		}
+
+		var perr *permanentError
+		if errors.As(err, &perr) {
+			return perr.err
+		}
+		if attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		wait := p.delay(attempt)
+		var ra retryAfterer
+		if errors.As(err, &ra) && ra.retryAfter() > 0 {
+			wait = min(ra.retryAfter(), p.maxRetryAfter())
+		}
+		if onRetry != nil {
+			onRetry(attempt+1, wait, err)
+		}
+
+		after := p.after
+		if after == nil {
+			after = time.After
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-after(wait):
+		}
+	}
+}
+
+func (p RetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxRetryAfter <= 0 {
+		return 5 * time.Minute
+	}
+	return p.MaxRetryAfter
+}
+
+// delay returns the wait after the failure of the attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = time.Second
+	}
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+
+	wait := base
+	for i := 1; i < attempt && wait < maxDelay; i++ {
+		wait *= 2
+	}
+	if wait > maxDelay {
+		wait = maxDelay
+	}
+
+	if p.Jitter > 0 {
+		random := p.random
+		if random == nil {
+			random = rand.Float64
+		}
+		jitter := min(p.Jitter, 1)
+		wait -= time.Duration(float64(wait) * jitter * random())
+	}
+	return wait
+}
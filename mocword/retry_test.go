@@ -0,0 +1,160 @@
+package mocword
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock replaces time.After in a RetryPolicy, recording the waits and
+// returning at once.
+type fakeClock struct {
+	waits []time.Duration
+}
+
+func (c *fakeClock) after(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func equalDurations(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{
+			name:   "default",
+			policy: RetryPolicy{},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+		{
+			name:   "capped",
+			policy: RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond},
+			want:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond},
+		},
+		{
+			name:   "jitter",
+			policy: RetryPolicy{BaseDelay: time.Second, Jitter: 0.5, random: func() float64 { return 0.5 }},
+			want:   []time.Duration{750 * time.Millisecond, 1500 * time.Millisecond, 3 * time.Second},
+		},
+		{
+			name:   "full jitter",
+			policy: RetryPolicy{BaseDelay: time.Second, Jitter: 2, random: func() float64 { return 1 }},
+			want:   []time.Duration{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		var got []time.Duration
+		for attempt := 1; attempt <= len(tt.want); attempt++ {
+			got = append(got, tt.policy.delay(attempt))
+		}
+		if !equalDurations(got, tt.want) {
+			t.Errorf("%s: delays = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		errs      []error
+		wantWaits []time.Duration
+		wantErr   error
+	}{
+		{
+			name:      "succeeds",
+			policy:    RetryPolicy{MaxAttempts: 5},
+			errs:      []error{errTransient, errTransient, nil},
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "gives up",
+			policy:    RetryPolicy{MaxAttempts: 3},
+			errs:      []error{errTransient, errTransient, errTransient, nil},
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+			wantErr:   errTransient,
+		},
+		{
+			name:    "permanent",
+			policy:  RetryPolicy{MaxAttempts: 3},
+			errs:    []error{Permanent(errTransient), nil},
+			wantErr: errTransient,
+		},
+		{
+			name:    "no retry",
+			policy:  RetryPolicy{},
+			errs:    []error{errTransient, nil},
+			wantErr: errTransient,
+		},
+		{
+			name:      "retry after",
+			policy:    RetryPolicy{MaxAttempts: 3},
+			errs:      []error{&retryableError{err: errTransient, after: 10 * time.Second}, errTransient, nil},
+			wantWaits: []time.Duration{10 * time.Second, 2 * time.Second},
+		},
+		{
+			name:      "retry after capped",
+			policy:    RetryPolicy{MaxAttempts: 2, MaxRetryAfter: 3 * time.Second},
+			errs:      []error{&retryableError{err: errTransient, after: time.Hour}, nil},
+			wantWaits: []time.Duration{3 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		clock := &fakeClock{}
+		tt.policy.after = clock.after
+
+		var calls int
+		err := tt.policy.Do(context.Background(), func() error {
+			err := tt.errs[calls]
+			calls++
+			return err
+		})
+		if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+			t.Errorf("%s: Do() = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if !equalDurations(clock.waits, tt.wantWaits) {
+			t.Errorf("%s: waits = %v, want %v", tt.name, clock.waits, tt.wantWaits)
+		}
+	}
+}
+
+func TestRetryPolicyCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 3, after: func(time.Duration) <-chan time.Time {
+		cancel()
+		return make(chan time.Time)
+	}}
+
+	var calls int
+	err := policy.Do(ctx, func() error {
+		calls++
+		return errors.New("transient")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() = %v, want context.Canceled", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
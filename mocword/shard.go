@@ -0,0 +1,228 @@
+package mocword
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// otherShard is the shard of the n-grams whose first token does not start
+// with a letter from a to z.
+const otherShard = "other"
+
+// shardKeys are the names of the shards in order.
+var shardKeys = append(strings.Split("abcdefghijklmnopqrstuvwxyz", ""), otherShard)
+
+// ShardKey returns the shard of an n-gram whose first token is token: its
+// lowercased initial letter from "a" to "z", or "other".
+func ShardKey(token string) string {
+	r, _ := utf8.DecodeRuneInString(token)
+	r = unicode.ToLower(r)
+	if 'a' <= r && r <= 'z' {
+		return string(r)
+	}
+	return otherShard
+}
+
+// ShardPath returns the database file of the shard key built with
+// BuildOptions.ShardByInitial into db, such as "ngrams_a.db" for
+// "ngrams.db". A lookup of n-grams starting with a word opens
+// ShardPath(db, ShardKey(word)).
+func ShardPath(db, key string) string {
+	ext := filepath.Ext(db)
+	return strings.TrimSuffix(db, ext) + "_" + key + ext
+}
+
+// sourcesShard is the shard whose sources table records the completion of
+// the sources of a sharded build. It is opened for every source, so that a
+// source is recorded even if none of its n-grams is in it.
+const sourcesShard = otherShard
+
+// shardedStore is a Store routing n-grams into SQLite databases by the
+// initial of their first token. The databases are opened on their first
+// n-gram and flushed together.
+//
+// Every shard records the rows it committed from the current source in its
+// own sources table, and skips them on resuming. The source is completed in
+// sourcesShard after the other shards.
+type shardedStore struct {
+	db     string
+	opts   sqliteOptions
+	shards map[string]*sqliteStore
+
+	// source is the url whose rows are inserted, and skip the number of its
+	// rows still to be skipped by each shard.
+	source string
+	skip   map[string]int64
+}
+
+func newShardedStore(db string, opts sqliteOptions) *shardedStore {
+	return &shardedStore{
+		db:     db,
+		opts:   opts,
+		shards: make(map[string]*sqliteStore),
+	}
+}
+
+// shard returns the shard of key, opening it if needed. A shard opened while
+// a source is loaded starts the source.
+func (s *shardedStore) shard(ctx context.Context, key string) (*sqliteStore, error) {
+	if shard, ok := s.shards[key]; ok {
+		return shard, nil
+	}
+
+	shard, err := s.openShard(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.source != "" {
+		if err := s.startShardSource(ctx, key, shard); err != nil {
+			return nil, err
+		}
+	}
+	return shard, nil
+}
+
+// openShard opens the shard of key.
+func (s *shardedStore) openShard(key string) (*sqliteStore, error) {
+	if shard, ok := s.shards[key]; ok {
+		return shard, nil
+	}
+
+	shard, err := newSQLiteStore(ShardPath(s.db, key), s.opts)
+	if err != nil {
+		return nil, err
+	}
+	s.shards[key] = shard
+	return shard, nil
+}
+
+func (s *shardedStore) startShardSource(ctx context.Context, key string, shard *sqliteStore) error {
+	rows, err := shard.startSource(ctx, s.source)
+	if err != nil {
+		return err
+	}
+	s.skip[key] = rows
+	return nil
+}
+
+func (s *shardedStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	key := ShardKey(tokens[0])
+	shard, err := s.shard(ctx, key)
+	if err != nil {
+		return err
+	}
+	if s.skip[key] > 0 {
+		s.skip[key]--
+		return nil
+	}
+	return shard.Insert(ctx, tokens, count)
+}
+
+func (s *shardedStore) Flush(ctx context.Context) error {
+	for _, key := range shardKeys {
+		if shard, ok := s.shards[key]; ok {
+			if err := shard.Flush(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *shardedStore) Close() error {
+	var firstErr error
+	for _, key := range shardKeys {
+		if shard, ok := s.shards[key]; ok {
+			if err := shard.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (s *shardedStore) sourceCompleted(ctx context.Context, url string) (bool, error) {
+	shard, err := s.shard(ctx, sourcesShard)
+	if err != nil {
+		return false, err
+	}
+	return shard.sourceCompleted(ctx, url)
+}
+
+// startSource starts the source in every open shard and in the ones opened
+// until it is completed, and returns 0 since the rows committed by each of
+// them are skipped by Insert.
+func (s *shardedStore) startSource(ctx context.Context, url string) (int64, error) {
+	s.source, s.skip = url, make(map[string]int64)
+	if _, err := s.shard(ctx, sourcesShard); err != nil {
+		return 0, err
+	}
+	for key, shard := range s.shards {
+		if _, ok := s.skip[key]; ok {
+			continue
+		}
+		if err := s.startShardSource(ctx, key, shard); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+func (s *shardedStore) completeSource(ctx context.Context, url string) error {
+	for _, key := range shardKeys {
+		if s.skip[key] > 0 {
+			return fmt.Errorf("%d rows already loaded into shard %s are not found", s.skip[key], key)
+		}
+	}
+
+	for _, key := range shardKeys {
+		if shard, ok := s.shards[key]; ok && key != sourcesShard {
+			if err := shard.completeSource(ctx, url); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.shards[sourcesShard].completeSource(ctx, url); err != nil {
+		return err
+	}
+
+	s.source, s.skip = "", nil
+	return nil
+}
+
+// createIndexes creates the indexes of every shard, including the ones built
+// by a previous run.
+func (s *shardedStore) createIndexes(ngrams []int) error {
+	for _, key := range shardKeys {
+		if _, ok := s.shards[key]; !ok {
+			if _, err := os.Stat(ShardPath(s.db, key)); err != nil {
+				continue
+			}
+		}
+
+		shard, err := s.openShard(key)
+		if err != nil {
+			return err
+		}
+		if err := shard.createIndexes(ngrams); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shardedStore) optimize() error {
+	for _, key := range shardKeys {
+		if shard, ok := s.shards[key]; ok {
+			if err := shard.optimize(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
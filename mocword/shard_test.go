@@ -0,0 +1,54 @@
+package mocword
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestShardedStoreResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	urls := writeTestData(t, dir)
+	db := filepath.Join(dir, "ngrams.db")
+	opts := sqliteOptions{batchSize: 1}
+
+	interruptSource(t, newShardedStore(db, opts), urls[0], 2)
+
+	s := newShardedStore(db, opts)
+	m, err := loadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := build(ctx, s, "1", dir, urls, BuildOptions{}.buildOptions(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range urls {
+		completed, err := s.sourceCompleted(ctx, url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !completed {
+			t.Errorf("sourceCompleted(%q) = false, want true", url)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int64)
+	for _, key := range shardKeys {
+		fname := ShardPath(db, key)
+		if !fileExists(fname) {
+			continue
+		}
+		for word, count := range readCounts(t, fname) {
+			if got := ShardKey(word); got != key {
+				t.Errorf("%q is in shard %s, want %s", word, key, got)
+			}
+			counts[word] += count
+		}
+	}
+	assertCounts(t, db, counts, testDataCounts)
+}
@@ -0,0 +1,125 @@
+package mocword
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// dataFileName matches the name of a data file of the dataset, such as
+// "3-00012-of-00200.gz", ".zst" if re-compressed by a mirror, or without a
+// suffix if saved decompressed, whose first number is the ngram.
+var dataFileName = regexp.MustCompile(`^([1-5])-[0-9]{5}-of-[0-9]{5}(\.gz|\.zst)?$`)
+
+// scanSourceDir lists the data files under root as combos of the selected
+// langs and ngrams. The ngram of a file is told by its name and its language
+// by the nearest parent directory named after a language, as the dataset
+// storage is laid out, or by langs if a single language is selected. The
+// urls of the combos are file urls. Files of other names are warned about.
+func scanSourceDir(root string, langs, ngrams []string) ([]combo, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("cannot scan source dir: %w", err)
+	}
+
+	type key struct{ lang, ngram, dir string }
+	files := make(map[key][]string)
+
+	err = filepath.WalkDir(abs, func(fname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		match := dataFileName.FindStringSubmatch(d.Name())
+		if match == nil {
+			if !isMetadataFileName(d.Name()) {
+				slog.Warn("skipping unexpected file in source dir", "file", fname)
+			}
+			return nil
+		}
+
+		lang := dirLanguage(abs, filepath.Dir(fname))
+		if lang == "" && len(langs) == 1 {
+			lang = langs[0]
+		}
+		if lang == "" {
+			slog.Warn("skipping data file of unknown language in source dir", "file", fname)
+			return nil
+		}
+
+		ngram := match[1]
+		if !contains(langs, lang) || !contains(ngrams, ngram) {
+			slog.Debug("skipping unselected data file in source dir", "file", fname, "language", lang, "ngram", ngram)
+			return nil
+		}
+
+		k := key{lang: lang, ngram: ngram, dir: filepath.Dir(fname)}
+		files[k] = append(files[k], (&url.URL{Scheme: "file", Path: filepath.ToSlash(fname)}).String())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot scan source dir: %w", err)
+	}
+
+	keys := make([]key, 0, len(files))
+	for k := range files {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].lang != keys[j].lang {
+			return keys[i].lang < keys[j].lang
+		}
+		if keys[i].ngram != keys[j].ngram {
+			return keys[i].ngram < keys[j].ngram
+		}
+		return keys[i].dir < keys[j].dir
+	})
+
+	combos := make([]combo, 0, len(keys))
+	for _, k := range keys {
+		urls := files[k]
+		sort.Strings(urls)
+		combos = append(combos, combo{lang: k.lang, ngram: k.ngram, urls: urls, dir: k.dir})
+	}
+	return combos, nil
+}
+
+// dirLanguage returns the language named by the nearest directory from dir up
+// to root, or "" if there is none.
+func dirLanguage(root, dir string) string {
+	for {
+		if name := filepath.Base(dir); contains(Languages, name) {
+			return name
+		}
+		if dir == root {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// isMetadataFileName reports whether name is of a file which Download saves
+// besides the data files.
+func isMetadataFileName(name string) bool {
+	switch {
+	case name == manifestFileName, name == checksumsFileName:
+		return true
+	case strings.HasSuffix(name, ".part"), isTempFileName(name):
+		return true
+	case strings.Contains(name, "-totalcounts-") && strings.HasSuffix(name, ".tsv"):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package mocword
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sourceTracker is implemented by stores which record the ingestion of each
+// source file in the store itself, so that a build can skip the files which
+// are completely loaded and resume a partially loaded one without inserting
+// its rows twice.
+//
+// The rows of a source are inserted in a deterministic order, and the number
+// of rows committed so far is recorded in the same transactions as them.
+// Resuming skips that many rows, which requires the same build options as the
+// interrupted build.
+type sourceTracker interface {
+	// sourceCompleted reports whether url is completely loaded.
+	sourceCompleted(ctx context.Context, url string) (bool, error)
+
+	// startSource starts recording the rows inserted from url, and returns
+	// the number of rows already committed by a previous build.
+	startSource(ctx context.Context, url string) (int64, error)
+
+	// completeSource marks url as completely loaded. The rows must be
+	// flushed.
+	completeSource(ctx context.Context, url string) error
+}
+
+// createSourcesTable creates the table recording the source files of a
+// database and the number of their rows committed.
+func createSourcesTable(ex execer, t tableNames) error {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (url TEXT PRIMARY KEY, rows INTEGER NOT NULL, completed_at TEXT)", t.sources())
+	if _, err := ex.Exec(query); err != nil {
+		return fmt.Errorf("cannot create table %s: %w", t.sources(), err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) sourceCompleted(ctx context.Context, url string) (bool, error) {
+	var completedAt sql.NullString
+	err := s.db.QueryRowContext(ctx, "SELECT completed_at FROM "+s.opts.tables.sources()+" WHERE url = ?", url).Scan(&completedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot query sources: %w", err)
+	}
+	return completedAt.Valid, nil
+}
+
+func (s *sqliteStore) startSource(ctx context.Context, url string) (int64, error) {
+	var rows int64
+	err := s.db.QueryRowContext(ctx, "SELECT rows FROM "+s.opts.tables.sources()+" WHERE url = ?", url).Scan(&rows)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("cannot query sources: %w", err)
+	}
+
+	s.source, s.sourceRows = url, rows
+	return rows, nil
+}
+
+// saveSourceRows records the rows committed from the current source in tx.
+func (s *sqliteStore) saveSourceRows(tx *sql.Tx) error {
+	if s.source == "" {
+		return nil
+	}
+	_, err := tx.Exec(
+		"INSERT INTO "+s.opts.tables.sources()+" (url, rows) VALUES (?, ?) ON CONFLICT (url) DO UPDATE SET rows = excluded.rows",
+		s.source, s.sourceRows,
+	)
+	if err != nil {
+		return fmt.Errorf("cannot update sources: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) completeSource(ctx context.Context, url string) error {
+	completedAt := time.Now().UTC()
+	if s.opts.deterministic {
+		completedAt = time.Unix(0, 0).UTC()
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO "+s.opts.tables.sources()+" (url, rows, completed_at) VALUES (?, ?, ?) ON CONFLICT (url) DO UPDATE SET rows = excluded.rows, completed_at = excluded.completed_at",
+		url, s.sourceRows, completedAt.Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("cannot update sources: %w", err)
+	}
+
+	s.source, s.sourceRows = "", 0
+	return nil
+}
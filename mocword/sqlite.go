@@ -0,0 +1,634 @@
+package mocword
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ngramTableNames = []string{"one_grams", "two_grams", "three_grams", "four_grams", "five_grams"}
+
+func ngramTableName(n int) (string, error) {
+	if n < 1 || n > len(ngramTableNames) {
+		return "", fmt.Errorf("invalid ngram size: %d", n)
+	}
+	return ngramTableNames[n-1], nil
+}
+
+// tablePrefixPattern matches a valid BuildOptions.TablePrefix: a lowercase SQL
+// identifier, which PostgreSQL does not fold whether quoted or not, short
+// enough for the names of the tables and indexes prefixed with it to fit the
+// 63 bytes of PostgreSQL.
+var tablePrefixPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,38}$`)
+
+// ValidTablePrefix reports whether prefix is a valid BuildOptions.TablePrefix.
+func ValidTablePrefix(prefix string) bool {
+	return tablePrefixPattern.MatchString(prefix)
+}
+
+// tableNames names the tables of a database built with the prefix, which
+// are named "<prefix>_two_grams" and so on if it is not empty.
+type tableNames struct {
+	prefix string
+}
+
+func (t tableNames) name(table string) string {
+	if t.prefix == "" {
+		return table
+	}
+	return t.prefix + "_" + table
+}
+
+func (t tableNames) ngram(n int) (string, error) {
+	table, err := ngramTableName(n)
+	if err != nil {
+		return "", err
+	}
+	return t.name(table), nil
+}
+
+func (t tableNames) vocab() string { return t.name("vocab") }
+
+func (t tableNames) sources() string { return t.name("sources") }
+
+func (t tableNames) words() string { return t.name("words") }
+
+// sqliteOptions configures the pragmas of the database connections. Unless
+// safeMode is set, the database uses WAL journaling with the given
+// synchronous level and cache size, which speeds up bulk loads at the cost of
+// durability on power loss.
+type sqliteOptions struct {
+	safeMode    bool
+	synchronous string
+	cacheSize   int
+
+	// batchSize is the number of rows inserted per transaction.
+	batchSize int
+
+	// normalizeVocab stores the words of the n-gram tables as ids of the
+	// vocab table.
+	normalizeVocab bool
+
+	// upsert adds the count of an n-gram which is already in the database
+	// instead of inserting another row. The tables get a unique index on
+	// their words for it.
+	upsert bool
+
+	// deterministic records the completion of the sources without a
+	// timestamp, so that the same build yields the same bytes.
+	deterministic bool
+
+	// tables names the tables of the database.
+	tables tableNames
+}
+
+// SynchronousModes are the values of BuildOptions.SQLiteSynchronous.
+var SynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+
+func openDB(fname string, opts sqliteOptions) (*sql.DB, error) {
+	dsn := "file:" + fname
+	if !opts.safeMode {
+		dsn += "?_journal_mode=WAL&_synchronous=" + opts.synchronous
+		if opts.cacheSize != 0 {
+			dsn += fmt.Sprintf("&_cache_size=%d", opts.cacheSize)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open db: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open db: %w", err)
+	}
+	return db, nil
+}
+
+// removeDB removes the SQLite database fname with its WAL and shared memory
+// files if they exist.
+func removeDB(fname string) error {
+	for _, f := range []string{fname, fname + "-wal", fname + "-shm"} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceDB renames the closed SQLite database src to dst, removing the WAL
+// and shared memory files left by dst so that they are not applied to src,
+// and the ones left by src.
+func replaceDB(src, dst string) error {
+	for _, f := range []string{dst + "-wal", dst + "-shm"} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	return removeDB(src)
+}
+
+// checkpointDB moves the content of the WAL file into the main database file
+// and truncates the WAL file.
+func checkpointDB(db *sql.DB) error {
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("cannot checkpoint db: %w", err)
+	}
+	return nil
+}
+
+// createNgramTable creates the table of n-grams, which has columns word1 ...
+// wordN and count. The words are ids of the vocab table with vocab.
+func createNgramTable(ex execer, t tableNames, n int, vocab bool) error {
+	table, err := t.ngram(n)
+	if err != nil {
+		return fmt.Errorf("cannot create table: %w", err)
+	}
+
+	wordType := "TEXT NOT NULL"
+	if vocab {
+		wordType = fmt.Sprintf("INTEGER NOT NULL REFERENCES %s (id)", t.vocab())
+	}
+
+	var cols []string
+	for i := 1; i <= n; i++ {
+		cols = append(cols, fmt.Sprintf("word%d %s", i, wordType))
+	}
+	cols = append(cols, "count INTEGER NOT NULL")
+
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(cols, ", "))
+	if _, err := ex.Exec(query); err != nil {
+		return fmt.Errorf("cannot create table %s: %w", table, err)
+	}
+	return nil
+}
+
+// createVocabTable creates the table mapping the words of the n-gram tables to
+// their ids.
+func createVocabTable(ex execer, t tableNames) error {
+	query := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id INTEGER PRIMARY KEY, word TEXT NOT NULL UNIQUE)", t.vocab())
+	if _, err := ex.Exec(query); err != nil {
+		return fmt.Errorf("cannot create table %s: %w", t.vocab(), err)
+	}
+	return nil
+}
+
+// createNgramIndex creates the index for prefix lookups on the table of
+// n-grams. It is meant to be called after bulk loading, so that inserts do
+// not have to maintain the index.
+func createNgramIndex(db *sql.DB, t tableNames, n int) error {
+	table, err := t.ngram(n)
+	if err != nil {
+		return fmt.Errorf("cannot create index: %w", err)
+	}
+
+	prefixLen := n - 1
+	if prefixLen < 1 {
+		prefixLen = 1
+	}
+	var cols []string
+	for i := 1; i <= prefixLen; i++ {
+		cols = append(cols, fmt.Sprintf("word%d", i))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot create index on %s: %w", table, err)
+	}
+	query := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_prefix_idx ON %s (%s)", table, table, strings.Join(cols, ", "))
+	if _, err := tx.Exec(query); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("cannot create index on %s: %w", table, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("cannot create index on %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// createNgramUniqueIndex creates the unique index on the words of the table
+// of n-grams which upserts conflict on.
+func createNgramUniqueIndex(ex execer, t tableNames, n int) error {
+	table, err := t.ngram(n)
+	if err != nil {
+		return fmt.Errorf("cannot create index: %w", err)
+	}
+
+	query := fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_words_idx ON %s (%s)", table, table, strings.Join(wordColumns(n), ", "))
+	if _, err := ex.Exec(query); err != nil {
+		return fmt.Errorf("cannot create index on %s: %w", table, err)
+	}
+	return nil
+}
+
+// wordsViewQuery returns the select of the words (word, count) view of the
+// 1-grams, which are looked up through the vocab table with vocab.
+func wordsViewQuery(t tableNames, vocab bool) string {
+	table, _ := t.ngram(1)
+	if vocab {
+		return fmt.Sprintf("SELECT v.word AS word, g.count AS count FROM %s g JOIN %s v ON v.id = g.word1", table, t.vocab())
+	}
+	return fmt.Sprintf("SELECT word1 AS word, count FROM %s", table)
+}
+
+func wordColumns(n int) []string {
+	cols := make([]string, 0, n)
+	for i := 1; i <= n; i++ {
+		cols = append(cols, fmt.Sprintf("word%d", i))
+	}
+	return cols
+}
+
+func insertNgramQuery(t tableNames, n int, upsert bool) (string, error) {
+	table, err := t.ngram(n)
+	if err != nil {
+		return "", err
+	}
+
+	var cols, params []string
+	for i := 1; i <= n; i++ {
+		cols = append(cols, fmt.Sprintf("word%d", i))
+		params = append(params, "?")
+	}
+	cols = append(cols, "count")
+	params = append(params, "?")
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(cols, ", "), strings.Join(params, ", "))
+	if upsert {
+		query += fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET count = count + excluded.count", strings.Join(wordColumns(n), ", "))
+	}
+	return query, nil
+}
+
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// sqliteStore is a Store writing n-grams into a SQLite database, one table per
+// n-gram size. Rows are inserted with prepared statements inside transactions
+// which are committed every batchSize rows. Cancelling the context is checked
+// between batches, so at most one batch is lost on interruption.
+//
+// With normalizeVocab, new words are inserted into the vocab table in the
+// same transactions as the rows, and the ids of all the words are cached in
+// memory.
+type sqliteStore struct {
+	fname  string
+	db     *sql.DB
+	opts   sqliteOptions
+	tables map[int]bool
+
+	tx     *sql.Tx
+	stmts  map[int]*sql.Stmt
+	rows   int
+	closed bool
+	// newTables are the tables created by the current transaction, which
+	// are added to tables once it is committed.
+	newTables []int
+
+	vocab     map[string]int64
+	vocabStmt *sql.Stmt
+	// newWords are the words inserted into vocab by the current transaction,
+	// which are forgotten if it is rolled back.
+	newWords []string
+
+	// source is the url whose rows are inserted, and sourceRows the number of
+	// its rows inserted including the ones of previous builds.
+	source     string
+	sourceRows int64
+}
+
+func newSQLiteStore(fname string, opts sqliteOptions) (*sqliteStore, error) {
+	if opts.batchSize < 1 {
+		opts.batchSize = 1
+	}
+
+	db, err := openDB(fname, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sqliteStore{
+		fname:  fname,
+		db:     db,
+		opts:   opts,
+		tables: make(map[int]bool),
+		stmts:  make(map[int]*sql.Stmt),
+	}
+
+	if err := createSourcesTable(db, opts.tables); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if opts.normalizeVocab {
+		if err := s.loadVocab(); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// loadVocab creates the vocab table if missing and caches its content, so
+// that a resumed build reuses the ids.
+func (s *sqliteStore) loadVocab() error {
+	if err := createVocabTable(s.db, s.opts.tables); err != nil {
+		return err
+	}
+
+	s.vocab = make(map[string]int64)
+
+	rows, err := s.db.Query("SELECT id, word FROM " + s.opts.tables.vocab())
+	if err != nil {
+		return fmt.Errorf("cannot load vocab: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var word string
+		if err := rows.Scan(&id, &word); err != nil {
+			return fmt.Errorf("cannot load vocab: %w", err)
+		}
+		s.vocab[word] = id
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("cannot load vocab: %w", err)
+	}
+	return nil
+}
+
+// wordID returns the id of word, inserting it into vocab if it is new.
+func (s *sqliteStore) wordID(word string) (int64, error) {
+	if id, ok := s.vocab[word]; ok {
+		return id, nil
+	}
+
+	if s.vocabStmt == nil {
+		stmt, err := s.tx.Prepare(fmt.Sprintf("INSERT INTO %s (word) VALUES (?)", s.opts.tables.vocab()))
+		if err != nil {
+			return 0, fmt.Errorf("cannot prepare statement: %w", err)
+		}
+		s.vocabStmt = stmt
+	}
+
+	res, err := s.vocabStmt.Exec(word)
+	if err != nil {
+		return 0, fmt.Errorf("cannot insert into vocab: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("cannot insert into vocab: %w", err)
+	}
+
+	s.vocab[word] = id
+	s.newWords = append(s.newWords, word)
+	return id, nil
+}
+
+// vocabArgs is ngramArgs with the ids of tokens.
+func (s *sqliteStore) vocabArgs(tokens []string, count int64) ([]interface{}, error) {
+	args := make([]interface{}, 0, len(tokens)+1)
+	for _, token := range tokens {
+		id, err := s.wordID(token)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, id)
+	}
+	return append(args, count), nil
+}
+
+func (s *sqliteStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	n := len(tokens)
+
+	if s.tx == nil {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("cannot begin transaction: %w", err)
+		}
+		s.tx = tx
+	}
+
+	stmt, ok := s.stmts[n]
+	if !ok {
+		if err := s.ensureTable(s.tx, n); err != nil {
+			return err
+		}
+
+		query, err := insertNgramQuery(s.opts.tables, n, s.opts.upsert)
+		if err != nil {
+			return fmt.Errorf("cannot prepare statement: %w", err)
+		}
+		if stmt, err = s.tx.Prepare(query); err != nil {
+			return fmt.Errorf("cannot prepare statement: %w", err)
+		}
+		s.stmts[n] = stmt
+	}
+
+	args := ngramArgs(tokens, count)
+	if s.vocab != nil {
+		var err error
+		if args, err = s.vocabArgs(tokens, count); err != nil {
+			return err
+		}
+	}
+
+	if _, err := stmt.Exec(args...); err != nil {
+		return fmt.Errorf("cannot insert: %w", err)
+	}
+
+	s.rows++
+	s.sourceRows++
+	if s.rows >= s.opts.batchSize {
+		return s.Flush(ctx)
+	}
+	return nil
+}
+
+// Flush commits the current batch, if any.
+func (s *sqliteStore) Flush(ctx context.Context) error {
+	if s.tx == nil {
+		return nil
+	}
+
+	if err := s.saveSourceRows(s.tx); err != nil {
+		return err
+	}
+
+	s.closeStmts()
+	err := s.tx.Commit()
+	s.tx, s.rows = nil, 0
+	if err != nil {
+		s.forgetNewWords()
+		s.newTables = nil
+		return fmt.Errorf("cannot commit: %w", err)
+	}
+	s.newWords = nil
+	for _, n := range s.newTables {
+		s.tables[n] = true
+	}
+	s.newTables = nil
+
+	return ctx.Err()
+}
+
+// Close rolls back the rows which are not flushed, checkpoints the WAL file
+// and closes the database. Closing twice is a no-op.
+func (s *sqliteStore) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	if s.tx != nil {
+		s.closeStmts()
+		s.tx.Rollback()
+		s.tx, s.rows = nil, 0
+		s.forgetNewWords()
+		s.newTables = nil
+	}
+
+	if err := checkpointDB(s.db); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
+
+// createIndexes creates the indexes of the tables of ngrams. It is meant to
+// be called after bulk loading.
+func (s *sqliteStore) createIndexes(ngrams []int) error {
+	for _, n := range ngrams {
+		if err := s.ensureTable(s.db, n); err != nil {
+			return err
+		}
+		if err := createNgramIndex(s.db, s.opts.tables, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createWordsView creates the words view of the 1-grams, creating their table
+// if missing.
+func (s *sqliteStore) createWordsView() error {
+	if err := s.ensureTable(s.db, 1); err != nil {
+		return err
+	}
+	query := fmt.Sprintf("CREATE VIEW IF NOT EXISTS %s AS %s", s.opts.tables.words(), wordsViewQuery(s.opts.tables, s.opts.normalizeVocab))
+	if _, err := s.db.Exec(query); err != nil {
+		return fmt.Errorf("cannot create view %s: %w", s.opts.tables.words(), err)
+	}
+	return nil
+}
+
+// optimize compacts the database with VACUUM and updates the statistics of the
+// query planner with ANALYZE. The WAL file is checkpointed first so that the
+// sizes logged are the ones of the main file.
+func (s *sqliteStore) optimize() error {
+	return optimizeDB(s.db, s.fname)
+}
+
+// optimizeDB vacuums and analyzes db of the file fname, logging its size
+// before and after.
+func optimizeDB(db *sql.DB, fname string) error {
+	if err := checkpointDB(db); err != nil {
+		return err
+	}
+	before, err := fileSize(fname)
+	if err != nil {
+		return fmt.Errorf("cannot optimize db: %w", err)
+	}
+
+	if _, err := db.Exec("VACUUM"); err != nil {
+		return fmt.Errorf("cannot vacuum db: %w", err)
+	}
+	if _, err := db.Exec("ANALYZE"); err != nil {
+		return fmt.Errorf("cannot analyze db: %w", err)
+	}
+
+	if err := checkpointDB(db); err != nil {
+		return err
+	}
+	after, err := fileSize(fname)
+	if err != nil {
+		return fmt.Errorf("cannot optimize db: %w", err)
+	}
+
+	slog.Info("optimized db", "file", fname, "bytes_before", before, "bytes_after", after)
+	return nil
+}
+
+func fileSize(fname string) (int64, error) {
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *sqliteStore) ensureTable(ex execer, n int) error {
+	if s.tables[n] {
+		return nil
+	}
+	if err := createNgramTable(ex, s.opts.tables, n, s.vocab != nil); err != nil {
+		return err
+	}
+	if s.opts.upsert {
+		if err := createNgramUniqueIndex(ex, s.opts.tables, n); err != nil {
+			return err
+		}
+	}
+
+	// A table created by the transaction of a batch does not exist if it is
+	// rolled back.
+	if tx, ok := ex.(*sql.Tx); ok && tx == s.tx {
+		s.newTables = append(s.newTables, n)
+		return nil
+	}
+	s.tables[n] = true
+	return nil
+}
+
+func (s *sqliteStore) closeStmts() {
+	for n, stmt := range s.stmts {
+		stmt.Close()
+		delete(s.stmts, n)
+	}
+	if s.vocabStmt != nil {
+		s.vocabStmt.Close()
+		s.vocabStmt = nil
+	}
+}
+
+func (s *sqliteStore) forgetNewWords() {
+	for _, word := range s.newWords {
+		delete(s.vocab, word)
+	}
+	s.newWords = nil
+}
+
+func ngramArgs(tokens []string, count int64) []interface{} {
+	args := make([]interface{}, 0, len(tokens)+1)
+	for _, token := range tokens {
+		args = append(args, token)
+	}
+	return append(args, count)
+}
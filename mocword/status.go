@@ -0,0 +1,60 @@
+package mocword
+
+import (
+	"context"
+	"fmt"
+)
+
+// ComboStatus is the state of the data files of a language and ngram in the
+// directory of Download.
+type ComboStatus struct {
+	Language string
+	Ngram    string
+
+	// Files is the number of data files listed by the index page, Downloaded
+	// the number of them saved into the directory, and Built the number of
+	// them built into any database recorded in its manifest.
+	Files      int
+	Downloaded int
+	Built      int
+}
+
+// DownloadStatus tells how many of the data files selected by src are
+// downloaded into dir and built, without downloading any. It fetches the
+// index pages to know the files of each language and ngram, and a language
+// which does not publish an ngram is left out unless src is Strict.
+func DownloadStatus(ctx context.Context, src Source, dir string) ([]ComboStatus, error) {
+	if err := src.validate(); err != nil {
+		return nil, fmt.Errorf("cannot tell status: %w", err)
+	}
+
+	m, err := loadManifest(dir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	ds := src.dataset(ctx)
+	combos, err := src.combos(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]ComboStatus, 0, len(combos))
+	for _, c := range combos {
+		cdir := comboDir(dir, ds.version, c)
+		status := ComboStatus{Language: c.lang, Ngram: c.ngram, Files: len(c.urls)}
+		for _, url := range c.urls {
+			if fileExists(findDataFile(cdir, url)) {
+				status.Downloaded++
+			}
+			for _, built := range m.Built {
+				if _, ok := built[url]; ok {
+					status.Built++
+					break
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
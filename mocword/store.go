@@ -0,0 +1,179 @@
+package mocword
+
+import (
+	"context"
+	"fmt"
+)
+
+// Store is the destination of built n-grams. The build pipeline only depends
+// on this interface, so that other backends can be plugged in.
+type Store interface {
+	// Insert adds an n-gram with its total match count. Inserted n-grams may
+	// be buffered until Flush.
+	Insert(ctx context.Context, tokens []string, count int64) error
+
+	// Flush persists the buffered n-grams.
+	Flush(ctx context.Context) error
+
+	// Close releases the store. N-grams which are not flushed may be lost.
+	Close() error
+}
+
+// indexer is implemented by stores which create their indexes after bulk
+// loading rather than maintaining them on every insert.
+type indexer interface {
+	createIndexes(ngrams []int) error
+}
+
+// optimizer is implemented by stores which can be compacted and analyzed
+// after the build.
+type optimizer interface {
+	optimize() error
+}
+
+// wordsViewer is implemented by stores which can expose their 1-grams as a
+// words (word, count) view for dictionary consumers.
+type wordsViewer interface {
+	createWordsView() error
+}
+
+// finisher is implemented by stores which write their output only once the
+// build is complete. A store closed unfinished discards its output.
+type finisher interface {
+	finish() error
+}
+
+// multiStore inserts n-grams into every one of its stores.
+//
+// It tracks the sources of its members which are sourceTrackers: the rows a
+// member committed from a source in a previous build are skipped for that
+// member only, so that the other members still receive every row. A source is
+// completed only if every member is a sourceTracker which completed it.
+type multiStore struct {
+	stores []Store
+
+	// skip is the number of rows of the current source still to be skipped
+	// by each store.
+	skip []int64
+}
+
+func (ms *multiStore) Insert(ctx context.Context, tokens []string, count int64) error {
+	for i, s := range ms.stores {
+		if ms.skip != nil && ms.skip[i] > 0 {
+			ms.skip[i]--
+			continue
+		}
+		if err := s.Insert(ctx, tokens, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *multiStore) Flush(ctx context.Context) error {
+	for _, s := range ms.stores {
+		if err := s.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *multiStore) Close() error {
+	var firstErr error
+	for _, s := range ms.stores {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (ms *multiStore) sourceCompleted(ctx context.Context, url string) (bool, error) {
+	for _, s := range ms.stores {
+		tracker, ok := s.(sourceTracker)
+		if !ok {
+			return false, nil
+		}
+		completed, err := tracker.sourceCompleted(ctx, url)
+		if err != nil || !completed {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// startSource starts the source in every tracking member, and returns 0 since
+// the rows committed by each of them are skipped by Insert.
+func (ms *multiStore) startSource(ctx context.Context, url string) (int64, error) {
+	ms.skip = make([]int64, len(ms.stores))
+	for i, s := range ms.stores {
+		if tracker, ok := s.(sourceTracker); ok {
+			rows, err := tracker.startSource(ctx, url)
+			if err != nil {
+				return 0, err
+			}
+			ms.skip[i] = rows
+		}
+	}
+	return 0, nil
+}
+
+func (ms *multiStore) completeSource(ctx context.Context, url string) error {
+	for i, s := range ms.stores {
+		if ms.skip[i] > 0 {
+			return fmt.Errorf("%d rows already loaded are not found", ms.skip[i])
+		}
+		if tracker, ok := s.(sourceTracker); ok {
+			if err := tracker.completeSource(ctx, url); err != nil {
+				return err
+			}
+		}
+	}
+	ms.skip = nil
+	return nil
+}
+
+func (ms *multiStore) createIndexes(ngrams []int) error {
+	for _, s := range ms.stores {
+		if idx, ok := s.(indexer); ok {
+			if err := idx.createIndexes(ngrams); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ms *multiStore) createWordsView() error {
+	for _, s := range ms.stores {
+		if v, ok := s.(wordsViewer); ok {
+			if err := v.createWordsView(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ms *multiStore) finish() error {
+	for _, s := range ms.stores {
+		if f, ok := s.(finisher); ok {
+			if err := f.finish(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ms *multiStore) optimize() error {
+	for _, s := range ms.stores {
+		if o, ok := s.(optimizer); ok {
+			if err := o.optimize(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
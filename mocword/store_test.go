@@ -0,0 +1,359 @@
+package mocword
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testDataLines are the lines of two 1-gram data files, whose n-grams are
+// counted once each with the counts of testDataCounts.
+var testDataLines = [][]string{
+	{"apple\t2000,3,1\t2001,4,2", "banana\t2000,5,1", "cherry\t2001,7,3"},
+	{"date\t2000,2,1", "zebra\t2000,11,4", "42\t2001,1,1"},
+}
+
+var testDataCounts = map[string]int64{
+	"apple": 7, "banana": 5, "cherry": 7, "date": 2, "zebra": 11, "42": 1,
+}
+
+// writeTestData writes the files of testDataLines into dir and returns their
+// urls.
+func writeTestData(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var urls []string
+	for i, lines := range testDataLines {
+		name := "1-0000" + string(rune('0'+i)) + "-of-00002.gz"
+		writeGzip(t, filepath.Join(dir, name), strings.Join(lines, "\n")+"\n")
+		urls = append(urls, "https://example.com/"+name)
+	}
+	return urls
+}
+
+func writeGzip(t testing.TB, fname, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(fname, []byte(gzipString(t, content)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readCounts returns the 1-gram counts of the SQLite database fname, failing
+// if an n-gram is in several rows.
+func readCounts(t *testing.T, fname string) map[string]int64 {
+	t.Helper()
+	return readTableCounts(t, fname, "one_grams")
+}
+
+// readTableCounts returns the counts of the 1-gram table of the SQLite
+// database fname.
+func readTableCounts(t *testing.T, fname, table string) map[string]int64 {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", "file:"+fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT word1, count FROM " + table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var word string
+		var count int64
+		if err := rows.Scan(&word, &count); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := counts[word]; ok {
+			t.Errorf("%s: %q is in several rows", fname, word)
+		}
+		counts[word] += count
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+func assertCounts(t *testing.T, name string, got, want map[string]int64) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d n-grams %v, want %d %v", name, len(got), got, len(want), want)
+	}
+	for word, count := range want {
+		if got[word] != count {
+			t.Errorf("%s: count of %q = %d, want %d", name, word, got[word], count)
+		}
+	}
+}
+
+// interruptSource commits the first rows of the sorted n-grams of the first
+// file of testDataLines into store as an interrupted build does.
+func interruptSource(t *testing.T, store Store, url string, rows int) {
+	t.Helper()
+	ctx := context.Background()
+
+	tracker, ok := store.(sourceTracker)
+	if !ok {
+		t.Fatalf("%T is not a sourceTracker", store)
+	}
+	if _, err := tracker.startSource(ctx, url); err != nil {
+		t.Fatal(err)
+	}
+	for _, word := range []string{"apple", "banana", "cherry"}[:rows] {
+		if err := store.Insert(ctx, []string{word}, testDataCounts[word]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMultiStoreResume(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	urls := writeTestData(t, dir)
+	dbs := []string{filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db")}
+	opts := sqliteOptions{batchSize: 1}
+
+	// Only the first database has committed rows of the first file.
+	s, err := newSQLiteStore(dbs[0], opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interruptSource(t, s, urls[0], 2)
+
+	ms := &multiStore{}
+	for _, db := range dbs {
+		s, err := newSQLiteStore(db, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms.stores = append(ms.stores, s)
+	}
+
+	m, err := loadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := build(ctx, ms, "1", dir, urls, BuildOptions{}.buildOptions(), m); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, db := range dbs {
+		assertCounts(t, db, readCounts(t, db), testDataCounts)
+	}
+}
+
+func TestMultiStoreSourceCompleted(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	urls := writeTestData(t, dir)
+
+	s, err := newSQLiteStore(filepath.Join(dir, "a.db"), sqliteOptions{batchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := newJSONLStore(filepath.Join(dir, "a.jsonl"), exportCompression{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := &multiStore{stores: []Store{s, e}}
+	defer ms.Close()
+
+	m, err := loadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := build(ctx, ms, "1", dir, urls, BuildOptions{}.buildOptions(), m); err != nil {
+		t.Fatal(err)
+	}
+
+	// The export is not a sourceTracker, so a source is never completed
+	// for all the stores.
+	completed, err := ms.sourceCompleted(ctx, urls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed {
+		t.Errorf("sourceCompleted(%q) = true with an export, want false", urls[0])
+	}
+
+	completed, err = s.sourceCompleted(ctx, urls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !completed {
+		t.Errorf("sqlite sourceCompleted(%q) = false, want true", urls[0])
+	}
+}
+
+const benchRows = 10000
+
+// insertBenchRows inserts benchRows distinct 1-grams into store and flushes
+// them.
+func insertBenchRows(b *testing.B, store Store) {
+	b.Helper()
+	ctx := context.Background()
+
+	for i := 0; i < benchRows; i++ {
+		if err := store.Insert(ctx, []string{"word" + strconv.Itoa(i)}, int64(i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := store.Flush(ctx); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkSQLiteInsert(b *testing.B) {
+	for _, bm := range []struct {
+		name      string
+		batchSize int
+	}{
+		{"unbatched", 1},
+		{"batched", benchRows},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s, err := newSQLiteStore(filepath.Join(b.TempDir(), "ngrams.db"), sqliteOptions{batchSize: bm.batchSize})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				insertBenchRows(b, s)
+				if err := s.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSQLiteIndex(b *testing.B) {
+	for _, bm := range []struct {
+		name          string
+		before, after bool
+	}{
+		{name: "after", after: true},
+		{name: "before", before: true},
+		{name: "skip"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s, err := newSQLiteStore(filepath.Join(b.TempDir(), "ngrams.db"), sqliteOptions{batchSize: benchRows})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if bm.before {
+					if err := s.createIndexes([]int{1}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				insertBenchRows(b, s)
+				if bm.after {
+					if err := s.createIndexes([]int{1}); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := s.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkStoreInsert compares the stores. The PostgreSQL one inserts into
+// the bench_one_grams table of the database of $MOCWORD_TEST_DSN, and is
+// skipped without it.
+func BenchmarkStoreInsert(b *testing.B) {
+	ctx := context.Background()
+
+	for _, bm := range []struct {
+		name     string
+		newStore func(b *testing.B) Store
+	}{
+		{"sqlite", func(b *testing.B) Store {
+			s, err := newSQLiteStore(filepath.Join(b.TempDir(), "ngrams.db"), sqliteOptions{batchSize: benchRows})
+			if err != nil {
+				b.Fatal(err)
+			}
+			return s
+		}},
+		{"postgres", func(b *testing.B) Store {
+			dsn := os.Getenv("MOCWORD_TEST_DSN")
+			if dsn == "" {
+				b.Skip("MOCWORD_TEST_DSN is not set")
+			}
+			tables := tableNames{prefix: "bench"}
+			s, err := newPostgresStore(ctx, dsn, postgresOptions{batchSize: benchRows, tables: tables})
+			if err != nil {
+				b.Fatal(err)
+			}
+			table, _ := tables.ngram(1)
+			if _, err := s.conn.Exec(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", table)); err != nil {
+				b.Fatal(err)
+			}
+			return s
+		}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				s := bm.newStore(b)
+				b.StartTimer()
+
+				insertBenchRows(b, s)
+				if err := s.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPendingSourcesCanceled(t *testing.T) {
+	dir := t.TempDir()
+	urls := writeTestData(t, dir)
+
+	s, err := newSQLiteStore(filepath.Join(dir, "a.db"), sqliteOptions{batchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	m, err := loadManifest(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pendingSources(ctx, s, urls, m); err == nil {
+		t.Error("pendingSources() with a canceled context succeeded, want an error")
+	}
+}
@@ -0,0 +1,99 @@
+package mocword
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// StreamNgrams downloads the data files selected by src and sends their
+// Ngrams on the returned channel as they are parsed, without saving anything
+// to disk, so that they may be fed to another index or a model. The files are
+// read in the order of the languages, ngrams and urls of src, and the size of
+// an Ngram tells its ngram.
+//
+// A download failing before its first Ngram is sent is retried as configured
+// by src. The error channel receives the first failure, including the error
+// of ctx if it is done, and then both channels are closed, as they are when
+// every file is read.
+func StreamNgrams(ctx context.Context, src Source) (<-chan Ngram, <-chan error) {
+	ngrams := make(chan Ngram)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(ngrams)
+
+		if err := streamNgrams(ctx, src, ngrams); err != nil {
+			errc <- err
+		}
+	}()
+
+	return ngrams, errc
+}
+
+func streamNgrams(ctx context.Context, src Source, ngrams chan<- Ngram) error {
+	if err := src.validate(); err != nil {
+		return fmt.Errorf("cannot stream ngrams: %w", err)
+	}
+
+	ds := src.dataset(ctx)
+	combos, err := src.combos(ctx, ds)
+	if err != nil {
+		return err
+	}
+
+	dlOpts := src.downloadOptions(ds.client, "")
+	for _, c := range combos {
+		for _, url := range c.urls {
+			// A retry after an Ngram is sent would send it again.
+			var sent bool
+			err := dlOpts.retry.retry(ctx, func() error {
+				err := streamURLNgrams(ctx, dlOpts, url, ngrams, &sent)
+				if sent {
+					return Permanent(err)
+				}
+				return permanentUnlessRetryable(err)
+			}, dlOpts.logRetry(url))
+			if err != nil {
+				return fmt.Errorf("cannot stream ngrams of %s: %w", url, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// streamURLNgrams sends the Ngrams of the data file of url on ngrams, setting
+// sent once one is sent.
+func streamURLNgrams(ctx context.Context, dlOpts downloadOptions, url string, ngrams chan<- Ngram, sent *bool) error {
+	reqCtx := ctx
+	if dlOpts.timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, dlOpts.timeout)
+		defer cancel()
+	}
+
+	r, err := openDataURL(reqCtx, dlOpts.client, url, nil)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	sc := NewNgramScanner(r)
+	for sc.Scan() {
+		select {
+		case ngrams <- sc.Ngram():
+			*sent = true
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if errors.Is(err, errNgramRead) {
+			return &retryableError{err: err}
+		}
+		return err
+	}
+	return nil
+}
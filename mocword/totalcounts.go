@@ -0,0 +1,136 @@
+package mocword
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// YearCount is a per-year entry of a totalcounts file.
+type YearCount struct {
+	Year        int
+	MatchCount  int64
+	PageCount   int64
+	VolumeCount int64
+}
+
+// fetchTotalCounts downloads and parses the totalcounts file of lang and ngram.
+func fetchTotalCounts(ctx context.Context, ds dataset, lang, ngram string) ([]YearCount, error) {
+	url := ds.totalCountsURL(lang, ngram)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch total counts: %w", err)
+	}
+
+	resp, err := ds.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch total counts: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot fetch total counts: %s: %s", url, resp.Status)
+	}
+
+	counts, err := parseTotalCounts(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch total counts %s: %w", url, err)
+	}
+	return counts, nil
+}
+
+// parseTotalCounts parses whitespace separated "year,match_count,page_count,volume_count" tuples.
+func parseTotalCounts(r io.Reader) ([]YearCount, error) {
+	var counts []YearCount
+
+	sc := bufio.NewScanner(r)
+	sc.Split(bufio.ScanWords)
+	for sc.Scan() {
+		c, err := parseYearCount(sc.Text())
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("cannot parse total counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+func parseYearCount(field string) (c YearCount, err error) {
+	elems := strings.Split(field, ",")
+	if len(elems) != 4 {
+		err = fmt.Errorf("invalid total count: %q", field)
+		return
+	}
+
+	if c.Year, err = strconv.Atoi(elems[0]); err != nil {
+		err = fmt.Errorf("invalid total count year: %q", field)
+		return
+	}
+	if c.MatchCount, err = strconv.ParseInt(elems[1], 10, 64); err != nil {
+		err = fmt.Errorf("invalid total count match count: %q", field)
+		return
+	}
+	if c.PageCount, err = strconv.ParseInt(elems[2], 10, 64); err != nil {
+		err = fmt.Errorf("invalid total count page count: %q", field)
+		return
+	}
+	if c.VolumeCount, err = strconv.ParseInt(elems[3], 10, 64); err != nil {
+		err = fmt.Errorf("invalid total count volume count: %q", field)
+		return
+	}
+
+	return
+}
+
+// totalMatchCount returns the aggregate corpus size in matches.
+func totalMatchCount(counts []YearCount) int64 {
+	var total int64
+	for _, c := range counts {
+		total += c.MatchCount
+	}
+	return total
+}
+
+func totalCountsFileName(lang, ngram string) string {
+	return fmt.Sprintf("%s-totalcounts-%s.tsv", lang, ngram)
+}
+
+// saveTotalCounts writes counts as "year\tmatch_count\tpage_count\tvolume_count" rows into dir.
+func saveTotalCounts(dir, lang, ngram string, counts []YearCount) error {
+	fname := filepath.Join(dir, totalCountsFileName(lang, ngram))
+
+	tmpfile, err := ioutil.TempFile(dir, totalCountsFileName(lang, ngram))
+	if err != nil {
+		return fmt.Errorf("cannot save total counts: %w", err)
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+
+	w := bufio.NewWriter(tmpfile)
+	for _, c := range counts {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\n", c.Year, c.MatchCount, c.PageCount, c.VolumeCount)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot save total counts: %w", err)
+	}
+	if err := tmpfile.Close(); err != nil {
+		return fmt.Errorf("cannot save total counts: %w", err)
+	}
+
+	if err := os.Rename(tmpfile.Name(), fname); err != nil {
+		return fmt.Errorf("cannot save total counts: %w", err)
+	}
+	return nil
+}
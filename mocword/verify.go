@@ -0,0 +1,221 @@
+package mocword
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VerifyOptions configures VerifyCorpus.
+type VerifyOptions struct {
+	// Dir is the directory of the data files, laid out by Download or flat.
+	Dir string
+
+	// ChecksumFile is the file of "<sha256>  <url>" lines to verify the files
+	// against, checksums.txt in Dir if empty.
+	ChecksumFile string
+
+	// Concurrency is the number of files hashed at once, 1 if 0.
+	Concurrency int
+
+	// Progress reports the hashed files and bytes every ProgressInterval, or
+	// every 10 seconds if it is 0.
+	Progress         bool
+	ProgressInterval time.Duration
+}
+
+// VerifyCorpus recomputes the SHA-256 of every file listed in the checksums
+// of opts and compares them, logging each missing or mismatched file. It
+// fails if any is found.
+//
+// A file listed by url is looked up in the subdirectory of its version,
+// language and ngram, and otherwise by its name anywhere under Dir.
+func VerifyCorpus(ctx context.Context, opts VerifyOptions) error {
+	if opts.Dir == "" {
+		return errors.New("cannot verify: no dir")
+	}
+	checksumFile := opts.ChecksumFile
+	if checksumFile == "" {
+		checksumFile = filepath.Join(opts.Dir, checksumsFileName)
+	}
+
+	checksums, err := loadChecksums(checksumFile)
+	if err != nil {
+		return fmt.Errorf("cannot verify: %w", err)
+	}
+	names, err := indexFileNames(opts.Dir)
+	if err != nil {
+		return fmt.Errorf("cannot verify: %w", err)
+	}
+
+	keys := make([]string, 0, len(checksums))
+	for key := range checksums {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	type job struct {
+		key   string
+		fname string
+	}
+	var jobs []job
+	var totalBytes int64
+	var missing int
+	for _, key := range keys {
+		fname, ok := locateChecksumFile(opts.Dir, key, names)
+		if !ok {
+			slog.Warn("missing file", "file", key)
+			missing++
+			continue
+		}
+		if fi, err := os.Stat(fname); err == nil {
+			totalBytes += fi.Size()
+		}
+		jobs = append(jobs, job{key: key, fname: fname})
+	}
+
+	start := time.Now()
+	p := newProgress(len(jobs), totalBytes)
+	if opts.Progress {
+		interval := opts.ProgressInterval
+		if interval <= 0 {
+			interval = 10 * time.Second
+		}
+		p.run(interval)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	jobCh := make(chan job)
+	var mismatched, unreadable int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				sum, err := hashFile(j.fname, p)
+				mu.Lock()
+				switch {
+				case err != nil:
+					slog.Warn("cannot verify file", "file", j.fname, "error", err)
+					unreadable++
+					p.fileFailed()
+				case sum != checksums[j.key]:
+					slog.Warn("checksum mismatch", "file", j.fname, "expected", checksums[j.key], "got", sum)
+					mismatched++
+					p.fileFailed()
+				default:
+					slog.Debug("verified file", "file", j.fname)
+					p.fileDone()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, j := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		jobCh <- j
+	}
+	close(jobCh)
+	wg.Wait()
+
+	if opts.Progress {
+		p.Stop()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("cannot verify: %w", err)
+	}
+
+	failed := missing + mismatched + unreadable
+	slog.Info("verified corpus",
+		"files", len(keys)-failed, "missing", missing, "mismatched", mismatched, "unreadable", unreadable,
+		"duration", time.Since(start).Round(time.Second),
+	)
+	if failed > 0 {
+		return fmt.Errorf("cannot verify %s: %d of %d files failed", opts.Dir, failed, len(keys))
+	}
+	return nil
+}
+
+// indexFileNames maps the names of the regular files under dir to their
+// paths.
+func indexFileNames(dir string) (map[string][]string, error) {
+	names := make(map[string][]string)
+	err := filepath.WalkDir(dir, func(fname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			names[d.Name()] = append(names[d.Name()], fname)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// locateChecksumFile returns the file of dir of the key of a checksum, which
+// is a data url or a file name. A name found more than once in names, which
+// is told by indexFileNames, is not located.
+func locateChecksumFile(dir, key string, names map[string][]string) (string, bool) {
+	if u, err := url.Parse(key); err == nil && u.Scheme != "" {
+		elems := strings.Split(strings.Trim(u.Path, "/"), "/")
+		name := elems[len(elems)-1]
+		if m := dataFileName.FindStringSubmatch(name); m != nil && len(elems) >= 3 {
+			version, lang := elems[len(elems)-3], elems[len(elems)-2]
+			fname := filepath.Join(comboDir(dir, version, combo{lang: lang, ngram: m[1]}), name)
+			if fileExists(fname) {
+				return fname, true
+			}
+		}
+		key = name
+	}
+
+	if fname := filepath.Join(dir, filepath.FromSlash(key)); fileExists(fname) {
+		return fname, true
+	}
+	if fnames := names[path.Base(key)]; len(fnames) == 1 {
+		return fnames[0], true
+	}
+	return "", false
+}
+
+// hashFile returns the SHA-256 of the file fname, counting the bytes read in
+// p.
+func hashFile(fname string, p *progress) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, p.reader(f)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
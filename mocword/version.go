@@ -0,0 +1,73 @@
+package mocword
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+const modulePath = "github.com/high-moctane/mocword-dataset-generator"
+
+// BuildInfo identifies the build of this module in the running binary.
+type BuildInfo struct {
+	// Version is the module version, "(devel)" if built from a checkout.
+	Version string
+
+	// Revision, Time and Modified are the VCS commit, its time and whether
+	// the checkout had local changes. They are empty if not stamped by go
+	// build.
+	Revision string
+	Time     string
+	Modified bool
+}
+
+// ReadBuildInfo returns the BuildInfo of the running binary, which may be
+// this module or a program depending on it.
+func ReadBuildInfo() BuildInfo {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{Version: "unknown"}
+	}
+
+	if bi.Main.Path != modulePath {
+		for _, dep := range bi.Deps {
+			if dep.Path == modulePath {
+				return BuildInfo{Version: dep.Version}
+			}
+		}
+	}
+
+	info := BuildInfo{Version: bi.Main.Version}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Revision = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+// String formats info as "<version> (<revision>[+dirty], <time>)", leaving
+// out the parts which are unknown.
+func (info BuildInfo) String() string {
+	var details []string
+	if info.Revision != "" {
+		rev := info.Revision
+		if info.Modified {
+			rev += "+dirty"
+		}
+		details = append(details, rev)
+	}
+	if info.Time != "" {
+		details = append(details, info.Time)
+	}
+
+	if len(details) == 0 {
+		return info.Version
+	}
+	return fmt.Sprintf("%s (%s)", info.Version, strings.Join(details, ", "))
+}